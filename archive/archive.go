@@ -0,0 +1,227 @@
+package archive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/logs"
+)
+
+// CombinedName is the name of the file entries are written to when all pods are archived to one file
+const CombinedName = "klogs"
+
+// rotatedFormat is the layout of the time a file was opened, added to its name when it is rotated
+const rotatedFormat = "20060102T150405Z"
+
+// Archive writes log entries to files as plain text, either to one file per container, laid out as
+// <dir>/<namespace>/<pod>/<container>.log, or to one combined file, with each line prefixed by the namespace, pod and
+// container it came from. When reading from several clusters, the kubeconfig context of each pod comes first. Files are
+// rotated once they reach a maximum size or age, renaming them with the time they were opened, and are optionally
+// compressed with gzip
+type Archive struct {
+	dir      string
+	combined bool
+	gzip     bool
+	// maxSize is the number of bytes after which files are rotated, or 0 for no limit
+	maxSize int64
+	// maxAge is how long after they were opened files are rotated, or 0 for no limit
+	maxAge time.Duration
+	files  map[string]*file
+	now    func() time.Time
+}
+
+type file struct {
+	path   string
+	f      *os.File
+	gz     *gzip.Writer
+	w      io.Writer
+	size   int64
+	opened time.Time
+}
+
+// New returns an Archive for the archive options, or nil if entries aren't archived
+func New(opts *args.Args) (*Archive, error) {
+	if opts.Archive == "" {
+		if opts.ArchiveOnly {
+			return nil, fmt.Errorf("--archive-only requires an --archive directory")
+		}
+		return nil, nil
+	}
+	a := &Archive{
+		dir:      opts.Archive,
+		combined: opts.ArchiveCombined,
+		gzip:     opts.Gzip,
+		files:    map[string]*file{},
+		now:      time.Now,
+	}
+	var err error
+	if opts.RotateSize != "" {
+		if a.maxSize, err = parseSize(opts.RotateSize); err != nil {
+			return nil, err
+		}
+	}
+	if opts.RotateEvery != "" {
+		if a.maxAge, err = time.ParseDuration(opts.RotateEvery); err != nil || a.maxAge <= 0 {
+			return nil, fmt.Errorf("invalid rotation interval %q, expected a duration such as 30m or 1h", opts.RotateEvery)
+		}
+	}
+	if err = os.MkdirAll(a.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create archive directory: %w", err)
+	}
+	return a, nil
+}
+
+// parseSize parses a number of bytes with an optional K, M or G suffix, with or without a trailing B or iB
+func parseSize(s string) (int64, error) {
+	size := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	multiplier := int64(1)
+	for i, unit := range []string{"K", "M", "G"} {
+		if strings.HasSuffix(size, unit) {
+			size, multiplier = strings.TrimSuffix(size, unit), int64(1)<<(10*(i+1))
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rotation size %q, expected a number of bytes such as 512K, 100M or 1G", s)
+	}
+	return n * multiplier, nil
+}
+
+// path returns the path of the file an entry is written to, without an extension
+func (a *Archive) path(e *logs.Entry) string {
+	if a.combined {
+		return filepath.Join(a.dir, CombinedName)
+	}
+	container := e.Container
+	if container == "" {
+		container = e.Pod
+	}
+	return filepath.Join(a.dir, element(e.Context), element(e.Namespace), element(e.Pod), element(container))
+}
+
+// element returns a name as a single path element, so that names containing path separators, such as the pods of
+// files named after their paths, are archived within the directory
+func element(name string) string {
+	name = strings.NewReplacer("/", "_", `\`, "_").Replace(name)
+	if name == "." || name == ".." {
+		return strings.Repeat("_", len(name))
+	}
+	return name
+}
+
+// format returns the line an entry is written as
+func (a *Archive) format(e *logs.Entry) string {
+	var line string
+	if a.combined {
+		if e.Notice {
+			line = "[klogs] "
+		} else {
+			source := []string{e.Namespace, e.Pod, e.Container}
+			if e.Context != "" {
+				source = append([]string{e.Context}, source...)
+			}
+			line = "[" + strings.Join(source, "/") + "] "
+		}
+	}
+	if !e.Time.IsZero() {
+		line += e.Time.UTC().Format(time.RFC3339Nano) + " "
+	}
+	line += e.Line
+	if e.Repeated > 1 {
+		line += fmt.Sprintf(" (repeated %d times)", e.Repeated)
+	}
+	return line + "\n"
+}
+
+// Write appends an entry to its file, rotating the file first if it has reached its maximum size or age. Notices are
+// only written to the combined file
+func (a *Archive) Write(e *logs.Entry) error {
+	if e.Notice && !a.combined {
+		return nil
+	}
+	path := a.path(e)
+	f, ok := a.files[path]
+	if ok && ((a.maxSize > 0 && f.size >= a.maxSize) || (a.maxAge > 0 && a.now().Sub(f.opened) >= a.maxAge)) {
+		if err := a.rotate(f); err != nil {
+			return err
+		}
+		ok = false
+	}
+	if !ok {
+		var err error
+		if f, err = a.open(path); err != nil {
+			return err
+		}
+		a.files[path] = f
+	}
+	n, err := io.WriteString(f.w, a.format(e))
+	f.size += int64(n)
+	return err
+}
+
+// open opens the file at path, appending to it if it exists
+func (a *Archive) open(path string) (*file, error) {
+	path += ".log"
+	if a.gzip {
+		path += ".gz"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	af := &file{path: path, f: f, w: f, opened: a.now()}
+	if a.gzip {
+		af.gz = gzip.NewWriter(f)
+		af.w = af.gz
+	}
+	return af, nil
+}
+
+func (f *file) close() error {
+	if f.gz != nil {
+		if err := f.gz.Close(); err != nil {
+			f.f.Close()
+			return err
+		}
+	}
+	return f.f.Close()
+}
+
+// rotate closes a file and renames it with the time it was opened
+func (a *Archive) rotate(f *file) error {
+	if err := f.close(); err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(f.path, ".gz"), ".log")
+	ext := strings.TrimPrefix(f.path, base)
+	rotated := base + "-" + f.opened.UTC().Format(rotatedFormat) + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = fmt.Sprintf("%s-%s.%d%s", base, f.opened.UTC().Format(rotatedFormat), i, ext)
+	}
+	return os.Rename(f.path, rotated)
+}
+
+// Close closes all open files, completing their gzip streams
+func (a *Archive) Close() error {
+	var first error
+	for path, f := range a.files {
+		if err := f.close(); err != nil && first == nil {
+			first = err
+		}
+		delete(a.files, path)
+	}
+	return first
+}
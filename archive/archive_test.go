@@ -0,0 +1,155 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/logs"
+)
+
+var ts = time.Date(2022, 11, 9, 12, 0, 0, 0, time.UTC)
+
+func read(t *testing.T, path string) string {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		it   string
+		opts *args.Args
+		err  string
+	}{
+		{
+			it:   "requires a directory to archive only",
+			opts: &args.Args{ArchiveOnly: true},
+			err:  "--archive-only requires an --archive directory",
+		},
+		{
+			it:   "rejects invalid rotation sizes",
+			opts: &args.Args{Archive: "dir", RotateSize: "lots"},
+			err:  `invalid rotation size "lots"`,
+		},
+		{
+			it:   "rejects invalid rotation intervals",
+			opts: &args.Args{Archive: "dir", RotateEvery: "daily"},
+			err:  `invalid rotation interval "daily"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.it, func(t *testing.T) {
+			_, err := New(tt.opts)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.err)
+		})
+	}
+	t.Run("returns nil if entries aren't archived", func(t *testing.T) {
+		a, err := New(&args.Args{})
+		require.NoError(t, err)
+		require.Nil(t, a)
+	})
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{"100": 100, "512K": 512 << 10, "10MB": 10 << 20, "1GiB": 1 << 30, "2m": 2 << 20} {
+		got, err := parseSize(s)
+		require.NoError(t, err)
+		require.Equal(t, want, got, s)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	t.Run("writes one file per container", func(t *testing.T) {
+		dir := t.TempDir()
+		a, err := New(&args.Args{Archive: dir})
+		require.NoError(t, err)
+		require.NoError(t, a.Write(&logs.Entry{Namespace: "ns", Pod: "foo", Container: "app", Line: "one", Time: ts}))
+		require.NoError(t, a.Write(&logs.Entry{Namespace: "ns", Pod: "foo", Container: "sidecar", Line: "two"}))
+		require.NoError(t, a.Write(&logs.Entry{Namespace: "ns", Pod: "foo", Container: "app", Line: "three", Repeated: 2}))
+		require.NoError(t, a.Write(&logs.Entry{Line: "pod ns/bar added", Notice: true}))
+		require.NoError(t, a.Close())
+		require.Equal(t, "2022-11-09T12:00:00Z one\nthree (repeated 2 times)\n", read(t, filepath.Join(dir, "ns", "foo", "app.log")))
+		require.Equal(t, "two\n", read(t, filepath.Join(dir, "ns", "foo", "sidecar.log")))
+	})
+	t.Run("writes all pods to a combined file", func(t *testing.T) {
+		dir := t.TempDir()
+		a, err := New(&args.Args{Archive: dir, ArchiveCombined: true})
+		require.NoError(t, err)
+		require.NoError(t, a.Write(&logs.Entry{Namespace: "ns", Pod: "foo", Container: "app", Line: "one", Time: ts}))
+		require.NoError(t, a.Write(&logs.Entry{Line: "pod ns/bar added", Notice: true}))
+		require.NoError(t, a.Write(&logs.Entry{Namespace: "ns", Pod: "bar", Container: "app", Line: "two\n\tat Main.main"}))
+		require.NoError(t, a.Close())
+		require.Equal(t, "[ns/foo/app] 2022-11-09T12:00:00Z one\n[klogs] pod ns/bar added\n[ns/bar/app] two\n\tat Main.main\n",
+			read(t, filepath.Join(dir, "klogs.log")))
+	})
+	t.Run("rotates files by size and age", func(t *testing.T) {
+		dir := t.TempDir()
+		a, err := New(&args.Args{Archive: dir, ArchiveCombined: true, RotateSize: "10", RotateEvery: "1h"})
+		require.NoError(t, err)
+		now := ts
+		a.now = func() time.Time { return now }
+		e := &logs.Entry{Namespace: "ns", Pod: "foo", Container: "app", Line: "one"}
+		require.NoError(t, a.Write(e))
+		now = now.Add(time.Minute)
+		require.NoError(t, a.Write(e))
+		now = now.Add(time.Hour)
+		require.NoError(t, a.Write(e))
+		require.NoError(t, a.Close())
+		require.Equal(t, "[ns/foo/app] one\n", read(t, filepath.Join(dir, "klogs-20221109T120000Z.log")))
+		require.Equal(t, "[ns/foo/app] one\n", read(t, filepath.Join(dir, "klogs-20221109T120100Z.log")))
+		require.Equal(t, "[ns/foo/app] one\n", read(t, filepath.Join(dir, "klogs.log")))
+	})
+	t.Run("keeps the files of pods named after paths within the directory", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(root, "work"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, "app.log"), []byte("one\n"), 0o644))
+		wd, err := os.Getwd()
+		require.NoError(t, err)
+		require.NoError(t, os.Chdir(filepath.Join(root, "work")))
+		defer func() { require.NoError(t, os.Chdir(wd)) }()
+		opts := &args.Args{File: []string{"../app.log"}, Archive: "out"}
+		a, err := New(opts)
+		require.NoError(t, err)
+		logChan, _, err := logs.Read(context.Background(), opts, logs.Files(opts, nil))
+		require.NoError(t, err)
+		for e := range logChan {
+			require.NoError(t, a.Write(e))
+		}
+		require.NoError(t, a.Close())
+		var archived []string
+		require.NoError(t, filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				rel, _ := filepath.Rel(root, path)
+				archived = append(archived, filepath.ToSlash(rel))
+			}
+			return err
+		}))
+		require.Equal(t, []string{"app.log", "work/out/.._app.log/.._app.log.log"}, archived)
+		require.Equal(t, "one\n", read(t, filepath.Join(root, "work", "out", ".._app.log", ".._app.log.log")))
+	})
+	t.Run("compresses files with gzip", func(t *testing.T) {
+		dir := t.TempDir()
+		a, err := New(&args.Args{Archive: dir, Gzip: true})
+		require.NoError(t, err)
+		require.NoError(t, a.Write(&logs.Entry{Namespace: "ns", Pod: "foo", Container: "app", Line: "one"}))
+		require.NoError(t, a.Close())
+		f, err := os.Open(filepath.Join(dir, "ns", "foo", "app.log.gz"))
+		require.NoError(t, err)
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, "one\n", string(b))
+	})
+}
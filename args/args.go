@@ -10,15 +10,45 @@ import (
 	"github.com/ryantate13/klogs/fn"
 )
 
+// envList reads a comma separated list from an environment variable
+func envList(key string) []string {
+	if v := os.Getenv(key); v != "" {
+		return strings.Split(v, ",")
+	}
+	return nil
+}
+
 func defaults() *Args {
 	return &Args{
-		All:        os.Getenv("KLOGS_ALL") == "1",
-		KubeConfig: os.Getenv("KUBECONFIG"),
-		Context:    os.Getenv("KLOGS_CONTEXT"),
-		Namespace:  os.Getenv("KLOGS_NAMESPACE"),
-		Prefix:     os.Getenv("KLOGS_PREFIX") == "1",
-		JSON:       os.Getenv("KLOGS_JSON") == "1",
-		Theme:      fn.Coalesce(os.Getenv("KLOGS_THEME"), "nord"),
+		All:              os.Getenv("KLOGS_ALL") == "1",
+		KubeConfig:       os.Getenv("KUBECONFIG"),
+		Context:          os.Getenv("KLOGS_CONTEXT"),
+		Namespace:        os.Getenv("KLOGS_NAMESPACE"),
+		Prefix:           os.Getenv("KLOGS_PREFIX") == "1",
+		JSON:             os.Getenv("KLOGS_JSON") == "1",
+		Theme:            fn.Coalesce(os.Getenv("KLOGS_THEME"), "nord"),
+		Backend:          fn.Coalesce(os.Getenv("KLOGS_BACKEND"), "kubectl"),
+		Output:           fn.Coalesce(os.Getenv("KLOGS_OUTPUT"), "text"),
+		Merge:            os.Getenv("KLOGS_MERGE") == "1",
+		Highlight:        os.Getenv("KLOGS_HIGHLIGHT") == "1",
+		KeepNonJSON:      os.Getenv("KLOGS_KEEP_NON_JSON") == "1",
+		Template:         os.Getenv("KLOGS_TEMPLATE"),
+		Level:            os.Getenv("KLOGS_LEVEL"),
+		FieldMap:         envList("KLOGS_FIELD_MAP"),
+		Unwrap:           os.Getenv("KLOGS_UNWRAP") == "1",
+		Multiline:        os.Getenv("KLOGS_MULTILINE") == "1",
+		Stats:            os.Getenv("KLOGS_STATS") == "1",
+		Summary:          os.Getenv("KLOGS_SUMMARY") == "1",
+		Patterns:         os.Getenv("KLOGS_PATTERNS") == "1",
+		HighlightNew:     os.Getenv("KLOGS_HIGHLIGHT_NEW") == "1",
+		Top:              fn.Coalesce(os.Getenv("KLOGS_TOP"), "10"),
+		Collapse:         os.Getenv("KLOGS_COLLAPSE") == "1",
+		CollapsePatterns: os.Getenv("KLOGS_COLLAPSE_PATTERNS") == "1",
+		CollapseAfter:    fn.Coalesce(os.Getenv("KLOGS_COLLAPSE_AFTER"), "1s"),
+		CollapseMax:      fn.Coalesce(os.Getenv("KLOGS_COLLAPSE_MAX"), "1000"),
+		Kubectl:          fn.Coalesce(os.Getenv("KLOGS_KUBECTL"), "kubectl"),
+		KubectlFlags:     envList("KLOGS_KUBECTL_FLAGS"),
+		KubectlPrefix:    os.Getenv("KLOGS_KUBECTL_PREFIX"),
 	}
 }
 
@@ -58,28 +88,66 @@ func optFlags[T any](argStruct *T) (*hash_set.Set[string], *hash_set.Set[string]
 
 // Args encapsulates all the various flags/options for klogs
 type Args struct {
-	Help          bool
-	Version       bool
-	Query         []string `positional:"true" description:""`
-	All           bool
-	AllNamespaces bool `short:"" long:"all-namespaces"`
-	AllContainers bool `short:"" long:"all-containers"`
-	Label         []string
-	LimitBytes    string `short:"" long:"limit-bytes"`
-	Since         string
-	SinceTime     string `short:"" long:"since-time"`
-	Tail          string `short:"" long:"tail"`
-	Follow        bool
-	Timestamps    bool `short:"" long:"timestamps"`
-	Previous      bool `short:""`
-	KubeConfig    string
-	Context       string `short:"C"`
-	Container     string
-	Namespace     string
-	Prefix        bool
-	JSON          bool
-	Theme         string
-	ListThemes    bool `short:"" long:"list-themes"`
+	Help             bool
+	Version          bool
+	Query            []string `positional:"true" description:""`
+	All              bool
+	AllNamespaces    bool `short:"" long:"all-namespaces"`
+	AllContainers    bool `short:"" long:"all-containers"`
+	Label            []string
+	LimitBytes       string `short:"" long:"limit-bytes"`
+	Since            string
+	SinceTime        string `short:"" long:"since-time"`
+	Tail             string `short:"" long:"tail"`
+	Follow           bool
+	Timestamps       bool `short:"" long:"timestamps"`
+	Previous         bool `short:""`
+	KubeConfig       string
+	Context          string `short:"C"`
+	Container        string
+	Namespace        string
+	Prefix           bool
+	JSON             bool
+	Theme            string
+	ListThemes       bool   `short:"" long:"list-themes"`
+	Backend          string `short:""`
+	Output           string `short:"o"`
+	Merge            bool
+	Include          []string
+	Exclude          []string `short:"x"`
+	Highlight        bool     `short:""`
+	Where            string   `short:"w"`
+	KeepNonJSON      bool     `short:"" long:"keep-non-json"`
+	Fields           string   `short:""`
+	Template         string   `short:""`
+	Level            string   `short:""`
+	FieldMap         []string `short:"" long:"field-map"`
+	Unwrap           bool     `short:""`
+	Multiline        bool     `short:""`
+	EntryStart       []string `short:"" long:"entry-start"`
+	Stats            bool     `short:""`
+	Summary          bool     `short:""`
+	Patterns         bool     `short:""`
+	HighlightNew     bool     `short:"" long:"highlight-new"`
+	Top              string   `short:""`
+	Collapse         bool     `short:""`
+	CollapsePatterns bool     `short:"" long:"collapse-patterns"`
+	CollapseAfter    string   `short:"" long:"collapse-after"`
+	CollapseMax      string   `short:"" long:"collapse-max"`
+	Archive          string   `short:""`
+	ArchiveOnly      bool     `short:"" long:"archive-only"`
+	ArchiveCombined  bool     `short:"" long:"archive-combined"`
+	Gzip             bool     `short:""`
+	RotateSize       string   `short:"" long:"rotate-size"`
+	RotateEvery      string   `short:"" long:"rotate-every"`
+	Record           string   `short:""`
+	Replay           string   `short:""`
+	Realtime         bool     `short:""`
+	File             []string `short:""`
+	LogDir           string   `short:"" long:"log-dir"`
+	Kubectl          string   `short:""`
+	KubectlFlags     []string `short:"" long:"kubectl-flag"`
+	KubectlPrefix    string   `short:"" long:"kubectl-prefix"`
 }
 
 // Usage returns the documentation string for the command
@@ -92,39 +160,99 @@ Usage: klogs [flags] [options] <search terms>...
 Example: klogs -f service-one service-two # follow logs of all pods for service-one and service-two
 
 Flags:
-	-h | --help           Show this help message and quit
-	-v | --version        Show the application version
-	-a | --all            All pod name queries must match. Default is to show logs for pods where any name query matches
-	   | --all-namespaces Query for pods in all namespaces
-	   | --all-containers Get all containers' logs in the pod(s)
-	-f | --follow         Follow log output
-	   | --timestamps     Include timestamps on each line in the log output. Defaults to false
-	   | --previous       If true, print the logs for the previous instance of the container in a pod if it exists. Defaults to false
-	-p | --prefix         Prefix each pod's logs entries with [pod name]
-	-j | --json           Add syntax highlighting for JSON log entries. Only available if outputting to a TTY that supports color
-	   | --list-themes    List all available JSON highlighting theme names and exit
+	-h | --help              Show this help message and quit
+	-v | --version           Show the application version
+	-a | --all               All pod name queries must match. Default is to show logs for pods where any name query matches
+	   | --all-namespaces    Query for pods in all namespaces
+	   | --all-containers    Get all containers' logs in the pod(s)
+	-f | --follow            Follow log output. Pods matching the query are watched, so new pods are streamed as they start, and dropped streams are reconnected
+	   | --timestamps        Include timestamps on each line in the log output. Defaults to false
+	   | --previous          If true, print the logs for the previous instance of the container in a pod if it exists. Defaults to false
+	-p | --prefix            Prefix each pod's logs entries with [pod name]
+	-j | --json              Add syntax highlighting for JSON and logfmt log entries. Only available if outputting to a TTY that supports color
+	   | --list-themes       List all available JSON highlighting theme names and exit
+	-m | --merge             Merge the logs of all pods in timestamp order. While following, entries are held back for up to a second so that late arrivals can be put in order. Timestamps are only shown with --timestamps
+	   | --highlight         Highlight the parts of log lines matched by --include expressions. Only available if outputting to a TTY that supports color
+	   | --keep-non-json     Show log lines that are not JSON objects or logfmt when filtering with --where instead of hiding them
+	   | --unwrap            Unwrap JSON objects and arrays that are escaped inside JSON string values, so that they are highlighted, filtered and formatted like the rest of the entry
+	   | --multiline         Group the lines of multi-line entries, such as Go panics, Python tracebacks and Java, JavaScript, .NET and Ruby stack traces, into a single entry per container, so that they are kept together and filtered as one entry. While following, entries are held back for a quarter of a second waiting for more lines
+	   | --stats             Show a table of the lines, bytes, lines per second and entries by level of each pod and container instead of log lines, refreshed every second on a terminal, and a summary with average rates on exit. Entries hidden by filters are not counted
+	   | --summary           Show log lines as usual, followed by the summary table of --stats on exit
+	   | --patterns          Show a table of the most common message patterns, with their counts and the pods logging them, instead of log lines, refreshed every second on a terminal and shown once more on exit. Patterns are found by masking the numbers, UUIDs, IP addresses and hex ids in messages and clustering similar messages, replacing the words that differ with <*>
+	   | --highlight-new     Mark log lines whose message doesn't match any pattern seen before with [new], using the patterns of --patterns
+	   | --collapse          Collapse consecutive identical lines from a container into one line with a "(repeated N times)" suffix. Lines are held back until a different line is written, the quiet interval of --collapse-after passes or they have been repeated --collapse-max times
+	   | --collapse-patterns Like --collapse but lines that only differ by numbers, UUIDs, IP addresses and hex ids are also repeats. The first line of each run is shown
+	   | --archive-only      Only archive log entries to the --archive directory instead of also showing them
+	   | --archive-combined  Archive the entries of all pods to one klogs.log file in the --archive directory, prefixing each line with [<namespace>/<pod>/<container>]
+	   | --gzip              Compress archived files with gzip, adding a .gz extension
+	   | --realtime          Replay the session of --replay at its original speed, waiting between lines as long as they were apart when recorded, instead of as fast as possible
 
 Options:
-	<search terms>...  One or more case-sensitive search terms for pod names. Pass "-" to read search terms from stdin. Default is to show logs for a pod if any term is a match 
-	-l | --label       Filter pods by one or more labels, pass additional -l arguments to add labels. Filtering is performed prior to name matching
-	-s | --since       Show logs only since this timestamp
-	   | --since-time  Only return logs after a specific date (RFC3339). Defaults to all logs. Only one of since-time / since may be used.
-	   | --tail        Lines of recent log file to display. Defaults to -1, showing all log lines.
-	-n | --namespace   Namespace pods must be in. Default is the default namespace for the cluster
-	-c | --container   Print the logs of this container
-	   | --limit-bytes Maximum bytes of logs to return. Defaults to no limit.
-	-k | --kubeconfig  Path to kube config file. Defaults to value of env var KUBECONFIG or ~/.kube/config if not present
-	-C | --context     The name of the kubeconfig context to use
-	-t | --theme       Theme to use for JSON syntax highlighting. Default is "nord". See "--list-themes"
+	<search terms>...     One or more case-sensitive search terms for pod names. Pass "-" to read search terms from stdin. Default is to show logs for a pod if any term is a match 
+	-l | --label          Filter pods by one or more labels, pass additional -l arguments to add labels. Filtering is performed prior to name matching
+	-s | --since          Show logs only since this timestamp
+	   | --since-time     Only return logs after a specific date (RFC3339). Defaults to all logs. Only one of since-time / since may be used.
+	   | --tail           Lines of recent log file to display. Defaults to -1, showing all log lines.
+	-n | --namespace      Namespace pods must be in. Default is the default namespace for the cluster
+	-c | --container      Print the logs of this container
+	   | --limit-bytes    Maximum bytes of logs to return. Defaults to no limit.
+	-k | --kubeconfig     Path to kube config file. Defaults to value of env var KUBECONFIG or ~/.kube/config if not present
+	-C | --context        The name of the kubeconfig context to use. Pass a comma separated list of names or glob patterns such as "prod-*", or additional -C arguments, to read from the clusters of several contexts in parallel, adding the context to the prefix and to ndjson output. Errors in one cluster are shown as notices without stopping the others
+	-t | --theme          Theme to use for JSON syntax highlighting. Default is "nord". See "--list-themes"
+	   | --backend        How to access the cluster, either "kubectl" to run kubectl commands, "api" to call the Kubernetes API directly using the kubeconfig, or "docker" to read the logs of local docker and docker compose containers instead of pods. With docker, search terms match container names, labels match container labels, and the namespace and container options match compose projects and services. Default is "kubectl"
+	-o | --output         Output format, either "text" for log lines as written, "human" to format JSON log entries as "HH:MM:SS LEVEL message key=value..." with nested objects flattened to dotted keys and colors from the theme, or "ndjson" for one JSON object per line with the pod, namespace, container, timestamp, level and message of each entry. Default is "text"
+	-i | --include        Only show log lines matching one or more regular expressions, pass additional -i arguments to add expressions
+	-x | --exclude        Hide log lines matching one or more regular expressions, pass additional -x arguments to add expressions
+	-w | --where          Only show JSON and logfmt log entries matching an expression, e.g. 'level in ("error","warn") && http.status >= 500 && user.id == "42"'. Fields are dotted paths compared with == != < <= > >= or in (...), regular expressions are matched with =~ and !~, a field on its own checks that it exists, and conditions are combined with && || ! and parentheses. Other lines are hidden unless --keep-non-json is set
+	   | --fields         Comma separated list of fields to show for JSON and logfmt log entries, e.g. "time,level,msg,trace_id". Nested fields are selected with dotted paths
+	   | --template       Go text/template used to format each log entry instead of the default text output, e.g. '{{.Pod}} {{.Timestamp.Format "15:04:05"}} {{.Field "level"}} {{.Message}}'. Available values are .Pod, .Namespace, .Container, .Stream, .Context, .Timestamp, .Level, .Message, .Repeated, the number of times a collapsed line was repeated, and .Fields, the entry parsed as JSON or logfmt, and .Field returns a field by its dotted path. The json function encodes a value as JSON
+	   | --level          Minimum level of log entries to show, one of trace, debug, info, warn, error or fatal. Levels are detected from JSON level, severity and lvl fields, logfmt level keys, klog headers and upper case level names. Lines without a detectable level are hidden
+	   | --field-map      Map a field of an in-house JSON log format to one of time, level, msg, caller, error or stack, e.g. "msg=event,level=sev". Pass additional --field-map arguments to add mappings. Mapped fields take precedence over those of the built-in zap, logrus, slog, bunyan, pino and Serilog schemas
+	   | --entry-start    Regular expression matching the first line of each log entry, e.g. '^\d{4}-\d{2}-\d{2} ', so that other lines are grouped with the entry before them. Replaces the built-in detection of --multiline when set. Pass additional --entry-start arguments to add expressions
+	   | --top            Number of patterns shown by --patterns in its table. Default is 10
+	   | --collapse-after How long repeated lines are held back while following with no more repeats before they are shown, e.g. 500ms. Default is 1s
+	   | --collapse-max   Number of repeats after which repeated lines are shown even if they are still being repeated, or 0 for no limit. Default is 1000
+	   | --archive        Directory to archive log entries to as plain text in addition to showing them, in files named <namespace>/<pod>/<container>.log
+	   | --rotate-size    Size after which archived files are rotated, e.g. 100M. Rotated files are renamed with the time they were started, e.g. app-20221109T120000Z.log. Default is no limit
+	   | --rotate-every   How often archived files are rotated, e.g. 1h. Default is never
+	   | --record         Record every raw line received from the cluster, with its pod, container and the time it was received, to a session file that can be replayed with --replay
+	   | --replay         Replay a session file written by --record instead of reading from the cluster, passing its lines through the same filters and output as live logs. Search terms are optional and labels are ignored when replaying
+	   | --file           Read log lines from a file instead of the cluster, or from stdin if "-", showing each file as a pod named after it. Lines starting with an RFC3339 timestamp, as in the output of kubectl logs --timestamps or docker logs -t when saved, use it as their timestamp. Pass additional --file arguments to read several files
+	   | --log-dir        Read the logs of pods from a directory of CRI or Docker json-file log files instead of the cluster, such as /var/log/pods or /var/log/containers on a node or a copy of them in a support bundle. Namespaces, pods and containers are taken from the file paths and partial lines are joined. With --follow files are followed as they grow and rotate. Search terms are optional and labels are ignored
+	   | --kubectl        Path of the binary run by the kubectl backend, such as "oc" or a wrapper script. The path is used as is, so it may contain spaces. Default is "kubectl"
+	   | --kubectl-flag   Global flag passed to the commands of the kubectl backend that list pods and stream their logs, such as "--as=admin", "--token=..." or "--request-timeout 5s", with the flag and its value separated by = or a space. Pass additional --kubectl-flag arguments to add flags
+	   | --kubectl-prefix Command the kubectl binary is run by, with its arguments separated by spaces, such as "microk8s" to run "microk8s kubectl" or "sudo" to run kubectl as root
 
 Environment Variables:
 	Example: KLOGS_NAMESPACE=foo KLOGS_CONTEXT=bar KLOGS_PREFIX=1 KLOGS_JSON=1 KLOGS_THEME=monokai klogs search-terms...
 	The following options/flags can be overridden via environment variables. Set value to "1" to enable a flag.
-	context:    KLOGS_CONTEXT
-	namespace:  KLOGS_NAMESPACE
-	prefix:     KLOGS_PREFIX
-	json:       KLOGS_JSON
-	theme:      KLOGS_THEME`
+	context:           KLOGS_CONTEXT
+	namespace:         KLOGS_NAMESPACE
+	prefix:            KLOGS_PREFIX
+	json:              KLOGS_JSON
+	theme:             KLOGS_THEME
+	backend:           KLOGS_BACKEND
+	output:            KLOGS_OUTPUT
+	merge:             KLOGS_MERGE
+	highlight:         KLOGS_HIGHLIGHT
+	keep-non-json:     KLOGS_KEEP_NON_JSON
+	template:          KLOGS_TEMPLATE
+	level:             KLOGS_LEVEL
+	field-map:         KLOGS_FIELD_MAP
+	unwrap:            KLOGS_UNWRAP
+	multiline:         KLOGS_MULTILINE
+	stats:             KLOGS_STATS
+	summary:           KLOGS_SUMMARY
+	patterns:          KLOGS_PATTERNS
+	highlight-new:     KLOGS_HIGHLIGHT_NEW
+	top:               KLOGS_TOP
+	collapse:          KLOGS_COLLAPSE
+	collapse-patterns: KLOGS_COLLAPSE_PATTERNS
+	collapse-after:    KLOGS_COLLAPSE_AFTER
+	collapse-max:      KLOGS_COLLAPSE_MAX
+	kubectl:           KLOGS_KUBECTL
+	kubectl-flag:      KLOGS_KUBECTL_FLAGS
+	kubectl-prefix:    KLOGS_KUBECTL_PREFIX`
 }
 
 // Parse takes an array of string args and returns the parsed Args struct
@@ -139,8 +267,12 @@ func Parse(argv []string) *Args {
 			a.Version = true
 		case arg == "-k" || arg == "--kubeconfig":
 			a.KubeConfig = argv[i+1]
-		case arg == "--context":
-			a.Context = argv[i+1]
+		case arg == "-C" || arg == "--context":
+			// additional contexts are added to a comma separated list
+			if a.Context != "" {
+				a.Context += ","
+			}
+			a.Context += argv[i+1]
 		case arg == "-c" || arg == "--container":
 			a.Container = argv[i+1]
 		case arg == "-n" || arg == "--namespace":
@@ -175,6 +307,82 @@ func Parse(argv []string) *Args {
 			a.Theme = argv[i+1]
 		case arg == "--list-themes":
 			a.ListThemes = true
+		case arg == "--backend":
+			a.Backend = argv[i+1]
+		case arg == "-o" || arg == "--output":
+			a.Output = argv[i+1]
+		case arg == "-m" || arg == "--merge":
+			a.Merge = true
+		case arg == "-i" || arg == "--include":
+			a.Include = append(a.Include, argv[i+1])
+		case arg == "-x" || arg == "--exclude":
+			a.Exclude = append(a.Exclude, argv[i+1])
+		case arg == "--highlight":
+			a.Highlight = true
+		case arg == "-w" || arg == "--where":
+			a.Where = argv[i+1]
+		case arg == "--keep-non-json":
+			a.KeepNonJSON = true
+		case arg == "--fields":
+			a.Fields = argv[i+1]
+		case arg == "--template":
+			a.Template = argv[i+1]
+		case arg == "--level":
+			a.Level = argv[i+1]
+		case arg == "--field-map":
+			a.FieldMap = append(a.FieldMap, argv[i+1])
+		case arg == "--unwrap":
+			a.Unwrap = true
+		case arg == "--multiline":
+			a.Multiline = true
+		case arg == "--entry-start":
+			a.EntryStart = append(a.EntryStart, argv[i+1])
+		case arg == "--stats":
+			a.Stats = true
+		case arg == "--summary":
+			a.Summary = true
+		case arg == "--patterns":
+			a.Patterns = true
+		case arg == "--highlight-new":
+			a.HighlightNew = true
+		case arg == "--top":
+			a.Top = argv[i+1]
+		case arg == "--collapse":
+			a.Collapse = true
+		case arg == "--collapse-patterns":
+			a.CollapsePatterns = true
+		case arg == "--collapse-after":
+			a.CollapseAfter = argv[i+1]
+		case arg == "--collapse-max":
+			a.CollapseMax = argv[i+1]
+		case arg == "--archive":
+			a.Archive = argv[i+1]
+		case arg == "--archive-only":
+			a.ArchiveOnly = true
+		case arg == "--archive-combined":
+			a.ArchiveCombined = true
+		case arg == "--gzip":
+			a.Gzip = true
+		case arg == "--rotate-size":
+			a.RotateSize = argv[i+1]
+		case arg == "--rotate-every":
+			a.RotateEvery = argv[i+1]
+		case arg == "--record":
+			a.Record = argv[i+1]
+		case arg == "--replay":
+			a.Replay = argv[i+1]
+		case arg == "--realtime":
+			a.Realtime = true
+		case arg == "--file":
+			a.File = append(a.File, argv[i+1])
+		case arg == "--log-dir":
+			a.LogDir = argv[i+1]
+		case arg == "--kubectl":
+			a.Kubectl = argv[i+1]
+		case arg == "--kubectl-flag":
+			a.KubectlFlags = append(a.KubectlFlags, argv[i+1])
+		case arg == "--kubectl-prefix":
+			a.KubectlPrefix = argv[i+1]
 		}
 	}
 	for i := len(argv) - 1; i >= 1; i-- {
@@ -206,5 +414,71 @@ func Parse(argv []string) *Args {
 	if a.Theme == "" {
 		a.Theme = d.Theme
 	}
+	if a.Backend == "" {
+		a.Backend = d.Backend
+	}
+	if a.Output == "" {
+		a.Output = d.Output
+	}
+	if a.Merge == false {
+		a.Merge = d.Merge
+	}
+	if a.Highlight == false {
+		a.Highlight = d.Highlight
+	}
+	if a.KeepNonJSON == false {
+		a.KeepNonJSON = d.KeepNonJSON
+	}
+	if a.Template == "" {
+		a.Template = d.Template
+	}
+	if a.Level == "" {
+		a.Level = d.Level
+	}
+	if len(a.FieldMap) == 0 {
+		a.FieldMap = d.FieldMap
+	}
+	if a.Unwrap == false {
+		a.Unwrap = d.Unwrap
+	}
+	if a.Multiline == false {
+		a.Multiline = d.Multiline
+	}
+	if a.Stats == false {
+		a.Stats = d.Stats
+	}
+	if a.Summary == false {
+		a.Summary = d.Summary
+	}
+	if a.Patterns == false {
+		a.Patterns = d.Patterns
+	}
+	if a.HighlightNew == false {
+		a.HighlightNew = d.HighlightNew
+	}
+	if a.Top == "" {
+		a.Top = d.Top
+	}
+	if a.Collapse == false {
+		a.Collapse = d.Collapse
+	}
+	if a.CollapsePatterns == false {
+		a.CollapsePatterns = d.CollapsePatterns
+	}
+	if a.CollapseAfter == "" {
+		a.CollapseAfter = d.CollapseAfter
+	}
+	if a.CollapseMax == "" {
+		a.CollapseMax = d.CollapseMax
+	}
+	if a.Kubectl == "" {
+		a.Kubectl = d.Kubectl
+	}
+	if len(a.KubectlFlags) == 0 {
+		a.KubectlFlags = d.KubectlFlags
+	}
+	if a.KubectlPrefix == "" {
+		a.KubectlPrefix = d.KubectlPrefix
+	}
 	return a
 }
@@ -27,6 +27,21 @@ func Test_optFlags(t *testing.T) {
 		"--previous",
 		"-p", "--prefix",
 		"-j", "--json",
+		"-m", "--merge",
+		"--highlight",
+		"--keep-non-json",
+		"--unwrap",
+		"--multiline",
+		"--stats",
+		"--summary",
+		"--patterns",
+		"--highlight-new",
+		"--collapse",
+		"--collapse-patterns",
+		"--archive-only",
+		"--archive-combined",
+		"--gzip",
+		"--realtime",
 	), flags)
 	require.Equal(t, hash_set.Of(
 		"-l", "--label",
@@ -39,6 +54,29 @@ func Test_optFlags(t *testing.T) {
 		"-k", "--kubeconfig",
 		"-C", "--context",
 		"-t", "--theme",
+		"--backend",
+		"-o", "--output",
+		"-i", "--include",
+		"-x", "--exclude",
+		"-w", "--where",
+		"--fields",
+		"--template",
+		"--level",
+		"--field-map",
+		"--entry-start",
+		"--top",
+		"--collapse-after",
+		"--collapse-max",
+		"--archive",
+		"--rotate-size",
+		"--rotate-every",
+		"--record",
+		"--replay",
+		"--file",
+		"--log-dir",
+		"--kubectl",
+		"--kubectl-flag",
+		"--kubectl-prefix",
 	), opts)
 }
 
@@ -71,6 +109,28 @@ func TestParse(t *testing.T) {
 		"KLOGS_PREFIX",
 		"KLOGS_JSON",
 		"KLOGS_THEME",
+		"KLOGS_BACKEND",
+		"KLOGS_OUTPUT",
+		"KLOGS_MERGE",
+		"KLOGS_HIGHLIGHT",
+		"KLOGS_KEEP_NON_JSON",
+		"KLOGS_TEMPLATE",
+		"KLOGS_LEVEL",
+		"KLOGS_FIELD_MAP",
+		"KLOGS_UNWRAP",
+		"KLOGS_MULTILINE",
+		"KLOGS_STATS",
+		"KLOGS_SUMMARY",
+		"KLOGS_PATTERNS",
+		"KLOGS_HIGHLIGHT_NEW",
+		"KLOGS_TOP",
+		"KLOGS_COLLAPSE",
+		"KLOGS_COLLAPSE_PATTERNS",
+		"KLOGS_COLLAPSE_AFTER",
+		"KLOGS_COLLAPSE_MAX",
+		"KLOGS_KUBECTL",
+		"KLOGS_KUBECTL_FLAGS",
+		"KLOGS_KUBECTL_PREFIX",
 	} {
 		require.NoError(t, os.Unsetenv(k))
 	}
@@ -106,19 +166,26 @@ func TestParse(t *testing.T) {
 				"test",
 			},
 			want: &Args{
-				Help:       true,
-				Version:    true,
-				Query:      []string{"test"},
-				All:        true,
-				Label:      []string{"test"},
-				Since:      "test",
-				Follow:     true,
-				KubeConfig: "test",
-				Container:  "test",
-				Namespace:  "test",
-				Prefix:     true,
-				JSON:       true,
-				Theme:      "test",
+				Help:          true,
+				Version:       true,
+				Query:         []string{"test"},
+				All:           true,
+				Label:         []string{"test"},
+				Since:         "test",
+				Follow:        true,
+				KubeConfig:    "test",
+				Context:       "test",
+				Container:     "test",
+				Namespace:     "test",
+				Prefix:        true,
+				JSON:          true,
+				Theme:         "test",
+				Backend:       "kubectl",
+				Output:        "text",
+				Top:           "10",
+				CollapseAfter: "1s",
+				CollapseMax:   "1000",
+				Kubectl:       "kubectl",
 			},
 		},
 		{
@@ -145,52 +212,187 @@ func TestParse(t *testing.T) {
 				"--kubeconfig", "test",
 				"--context", "test",
 				"--theme", "test",
+				"--backend", "test",
+				"--output", "test",
+				"--merge",
+				"--include", "test",
+				"--exclude", "test",
+				"--highlight",
+				"--where", "test",
+				"--keep-non-json",
+				"--fields", "test",
+				"--template", "test",
+				"--level", "test",
+				"--field-map", "test",
+				"--unwrap",
+				"--multiline",
+				"--entry-start", "test",
+				"--stats",
+				"--summary",
+				"--patterns",
+				"--highlight-new",
+				"--top", "test",
+				"--collapse",
+				"--collapse-patterns",
+				"--collapse-after", "test",
+				"--collapse-max", "test",
+				"--archive", "test",
+				"--archive-only",
+				"--archive-combined",
+				"--gzip",
+				"--rotate-size", "test",
+				"--rotate-every", "test",
+				"--record", "test",
+				"--replay", "test",
+				"--realtime",
+				"--file", "test",
+				"--log-dir", "test",
+				"--kubectl", "test",
+				"--kubectl-flag", "test",
+				"--kubectl-prefix", "test",
 				"test",
 			},
 			want: &Args{
-				Help:          true,
-				Version:       true,
+				Help:             true,
+				Version:          true,
+				Query:            []string{"test"},
+				All:              true,
+				AllNamespaces:    true,
+				AllContainers:    true,
+				Label:            []string{"test"},
+				LimitBytes:       "test",
+				Since:            "test",
+				SinceTime:        "test",
+				Tail:             "test",
+				Follow:           true,
+				Timestamps:       true,
+				Previous:         true,
+				KubeConfig:       "test",
+				Context:          "test",
+				Container:        "test",
+				Namespace:        "test",
+				Prefix:           true,
+				JSON:             true,
+				Theme:            "test",
+				Backend:          "test",
+				Output:           "test",
+				Merge:            true,
+				Include:          []string{"test"},
+				Exclude:          []string{"test"},
+				Highlight:        true,
+				Where:            "test",
+				KeepNonJSON:      true,
+				Fields:           "test",
+				Template:         "test",
+				Level:            "test",
+				FieldMap:         []string{"test"},
+				Unwrap:           true,
+				Multiline:        true,
+				EntryStart:       []string{"test"},
+				Stats:            true,
+				Summary:          true,
+				Patterns:         true,
+				HighlightNew:     true,
+				Top:              "test",
+				Collapse:         true,
+				CollapsePatterns: true,
+				CollapseAfter:    "test",
+				CollapseMax:      "test",
+				Archive:          "test",
+				ArchiveOnly:      true,
+				ArchiveCombined:  true,
+				Gzip:             true,
+				RotateSize:       "test",
+				RotateEvery:      "test",
+				Record:           "test",
+				Replay:           "test",
+				Realtime:         true,
+				File:             []string{"test"},
+				LogDir:           "test",
+				Kubectl:          "test",
+				KubectlFlags:     []string{"test"},
+				KubectlPrefix:    "test",
+			},
+		},
+		{
+			it:   "joins repeated contexts into a list",
+			args: []string{"klogs", "-C", "us-*", "--context", "eu-west", "test"},
+			want: &Args{
 				Query:         []string{"test"},
-				All:           true,
-				AllNamespaces: true,
-				AllContainers: true,
-				Label:         []string{"test"},
-				LimitBytes:    "test",
-				Since:         "test",
-				SinceTime:     "test",
-				Tail:          "test",
-				Follow:        true,
-				Timestamps:    true,
-				Previous:      true,
-				KubeConfig:    "test",
-				Context:       "test",
-				Container:     "test",
-				Namespace:     "test",
-				Prefix:        true,
-				JSON:          true,
-				Theme:         "test",
+				Context:       "us-*,eu-west",
+				Theme:         "nord",
+				Backend:       "kubectl",
+				Output:        "text",
+				Top:           "10",
+				CollapseAfter: "1s",
+				CollapseMax:   "1000",
+				Kubectl:       "kubectl",
 			},
 		},
 		{
 			it:   "reads defaults from the environment",
 			args: []string{"klogs"},
 			want: &Args{
-				All:        true,
-				KubeConfig: "test",
-				Context:    "test",
-				Namespace:  "test",
-				Prefix:     true,
-				JSON:       true,
-				Theme:      "test",
+				All:              true,
+				KubeConfig:       "test",
+				Context:          "test",
+				Namespace:        "test",
+				Prefix:           true,
+				JSON:             true,
+				Theme:            "test",
+				Backend:          "test",
+				Output:           "test",
+				Merge:            true,
+				Highlight:        true,
+				KeepNonJSON:      true,
+				Template:         "test",
+				Level:            "test",
+				FieldMap:         []string{"msg=event", "level=sev"},
+				Unwrap:           true,
+				Multiline:        true,
+				Stats:            true,
+				Summary:          true,
+				Patterns:         true,
+				HighlightNew:     true,
+				Top:              "test",
+				Collapse:         true,
+				CollapsePatterns: true,
+				CollapseAfter:    "test",
+				CollapseMax:      "test",
+				Kubectl:          "test",
+				KubectlFlags:     []string{"--as=test", "--token=test"},
+				KubectlPrefix:    "microk8s",
 			},
 			env: map[string]string{
-				"KLOGS_ALL":       "1",
-				"KUBECONFIG":      "test",
-				"KLOGS_CONTEXT":   "test",
-				"KLOGS_NAMESPACE": "test",
-				"KLOGS_PREFIX":    "1",
-				"KLOGS_JSON":      "1",
-				"KLOGS_THEME":     "test",
+				"KLOGS_ALL":               "1",
+				"KUBECONFIG":              "test",
+				"KLOGS_CONTEXT":           "test",
+				"KLOGS_NAMESPACE":         "test",
+				"KLOGS_PREFIX":            "1",
+				"KLOGS_JSON":              "1",
+				"KLOGS_THEME":             "test",
+				"KLOGS_BACKEND":           "test",
+				"KLOGS_OUTPUT":            "test",
+				"KLOGS_MERGE":             "1",
+				"KLOGS_HIGHLIGHT":         "1",
+				"KLOGS_KEEP_NON_JSON":     "1",
+				"KLOGS_TEMPLATE":          "test",
+				"KLOGS_LEVEL":             "test",
+				"KLOGS_FIELD_MAP":         "msg=event,level=sev",
+				"KLOGS_UNWRAP":            "1",
+				"KLOGS_MULTILINE":         "1",
+				"KLOGS_STATS":             "1",
+				"KLOGS_SUMMARY":           "1",
+				"KLOGS_PATTERNS":          "1",
+				"KLOGS_HIGHLIGHT_NEW":     "1",
+				"KLOGS_TOP":               "test",
+				"KLOGS_COLLAPSE":          "1",
+				"KLOGS_COLLAPSE_PATTERNS": "1",
+				"KLOGS_COLLAPSE_AFTER":    "test",
+				"KLOGS_COLLAPSE_MAX":      "test",
+				"KLOGS_KUBECTL":           "test",
+				"KLOGS_KUBECTL_FLAGS":     "--as=test,--token=test",
+				"KLOGS_KUBECTL_PREFIX":    "microk8s",
 			},
 		},
 	}
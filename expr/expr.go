@@ -0,0 +1,459 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a compiled filter expression that is evaluated against the fields of JSON log entries. Expressions combine
+// conditions on field paths with &&, || and !, for example
+//
+//	level in ("error","warn") && http.status >= 500 && user.id == "42"
+//
+// A condition is either a path on its own, which checks that the field exists, or a path compared to a value with
+// ==, !=, <, <=, >, >=, =~ (regex match), !~ (regex does not match) or in (one of a list of values). Values are
+// double quoted strings with JSON style escapes, single quoted raw strings, numbers, true, false or null
+type Expr struct {
+	source string
+	root   node
+}
+
+// Parse compiles an expression
+func Parse(source string) (*Expr, error) {
+	p := &parser{source: source}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return &Expr{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Match reports whether the fields of a log entry satisfy the expression
+func (e *Expr) Match(fields map[string]interface{}) bool {
+	return e.root.eval(fields)
+}
+
+// Lookup returns the value at a dotted path in the fields of a log entry. Keys that contain dots themselves are
+// matched before nested objects, and numeric path segments index into arrays
+func Lookup(fields map[string]interface{}, path string) (interface{}, bool) {
+	return lookup(fields, strings.Split(path, "."))
+}
+
+func lookup(v interface{}, segments []string) (interface{}, bool) {
+	if len(segments) == 0 {
+		return v, true
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for i := len(segments); i > 0; i-- {
+			if child, ok := v[strings.Join(segments[:i], ".")]; ok {
+				if res, ok := lookup(child, segments[i:]); ok {
+					return res, true
+				}
+			}
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(segments[0]); err == nil && i >= 0 && i < len(v) {
+			return lookup(v[i], segments[1:])
+		}
+	}
+	return nil, false
+}
+
+type node interface {
+	eval(fields map[string]interface{}) bool
+}
+
+type and struct{ left, right node }
+
+func (n *and) eval(fields map[string]interface{}) bool {
+	return n.left.eval(fields) && n.right.eval(fields)
+}
+
+type or struct{ left, right node }
+
+func (n *or) eval(fields map[string]interface{}) bool {
+	return n.left.eval(fields) || n.right.eval(fields)
+}
+
+type not struct{ node node }
+
+func (n *not) eval(fields map[string]interface{}) bool {
+	return !n.node.eval(fields)
+}
+
+type exists struct{ path string }
+
+func (n *exists) eval(fields map[string]interface{}) bool {
+	_, ok := Lookup(fields, n.path)
+	return ok
+}
+
+type compare struct {
+	path   string
+	op     string
+	values []interface{}
+	re     *regexp.Regexp
+}
+
+func (n *compare) eval(fields map[string]interface{}) bool {
+	v, ok := Lookup(fields, n.path)
+	switch n.op {
+	case "=~", "!~":
+		return ok && v != nil && n.re.MatchString(toString(v)) == (n.op == "=~")
+	case "in":
+		for _, want := range n.values {
+			if equal(v, want) {
+				return true
+			}
+		}
+		return false
+	case "==":
+		return equal(v, n.values[0])
+	case "!=":
+		return !equal(v, n.values[0])
+	}
+	if !ok {
+		return false
+	}
+	c, ok := order(v, n.values[0])
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// equal compares a field to a value. Missing fields are equal to null, and numbers are equal to strings that parse as
+// the same number, since ids and status codes are logged as either
+func equal(v interface{}, want interface{}) bool {
+	if want == nil || v == nil {
+		return want == nil && v == nil
+	}
+	if b, isBool := want.(bool); isBool {
+		return v == b
+	}
+	c, comparable := order(v, want)
+	return comparable && c == 0
+}
+
+// order compares a field to a number or string, numerically if either is a number and the other is a number or a
+// numeric string, and otherwise as strings
+func order(v, want interface{}) (int, bool) {
+	a, aNum := toNumber(v)
+	b, bNum := toNumber(want)
+	_, vString := v.(string)
+	_, wantString := want.(string)
+	if aNum && bNum && !(vString && wantString) {
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	}
+	if !vString || !wantString {
+		return 0, false
+	}
+	return strings.Compare(v.(string), want.(string)), true
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPath
+	tokenString
+	tokenNumber
+	tokenOp
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value interface{}
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+type parser struct {
+	source string
+	tokens []token
+	i      int
+}
+
+func (p *parser) errorf(t token, format string, a ...interface{}) error {
+	return fmt.Errorf("invalid expression %q at position %d: %s", p.source, t.pos+1, fmt.Sprintf(format, a...))
+}
+
+// operators are listed longest first so that the lexer matches them greedily
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", ","}
+
+func isPathRune(r rune, first bool) bool {
+	if unicode.IsLetter(r) || r == '_' || r == '@' || r == '$' {
+		return true
+	}
+	return !first && (unicode.IsDigit(r) || r == '.' || r == '-')
+}
+
+func (p *parser) lex() error {
+	s := p.source
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != s[i] {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return p.errorf(token{pos: i}, "unterminated string")
+			}
+			text := s[i : j+1]
+			v := strings.ReplaceAll(text[1:len(text)-1], `\'`, `'`)
+			if r == '"' {
+				var err error
+				if v, err = strconv.Unquote(text); err != nil {
+					return p.errorf(token{pos: i}, "invalid string %s", text)
+				}
+			}
+			p.tokens = append(p.tokens, token{kind: tokenString, text: text, value: v, pos: i})
+			i = j + 1
+			continue
+		case r == '-' || r == '.' || unicode.IsDigit(r):
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || strings.ContainsRune(".eE+-", rune(s[j]))) {
+				j++
+			}
+			f, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return p.errorf(token{pos: i}, "invalid number %q", s[i:j])
+			}
+			p.tokens = append(p.tokens, token{kind: tokenNumber, text: s[i:j], value: f, pos: i})
+			i = j
+			continue
+		case isPathRune(r, true):
+			j := i + 1
+			for j < len(s) && isPathRune(rune(s[j]), false) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tokenPath, text: s[i:j], pos: i})
+			i = j
+			continue
+		}
+		matched := false
+		for _, op := range operators {
+			if strings.HasPrefix(s[i:], op) {
+				p.tokens = append(p.tokens, token{kind: tokenOp, text: op, pos: i})
+				i += len(op)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return p.errorf(token{pos: i}, "unexpected character %q", r)
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tokenEOF, pos: len(s)})
+	return nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokenEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOp && t.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return p.errorf(t, "expected %q but found %s", op, t)
+	}
+	return nil
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &or{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &and{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if p.accept("!") {
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &not{n}, nil
+	}
+	if p.accept("(") {
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	}
+	return p.condition()
+}
+
+func (p *parser) condition() (node, error) {
+	t := p.next()
+	if t.kind != tokenPath || t.text == "in" {
+		return nil, p.errorf(t, "expected a field path but found %s", t)
+	}
+	op := p.peek()
+	switch {
+	case op.kind == tokenPath && op.text == "in":
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		n := &compare{path: t.text, op: "in"}
+		for {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, v)
+			if !p.accept(",") {
+				break
+			}
+		}
+		return n, p.expect(")")
+	case op.kind != tokenOp:
+		return &exists{t.text}, nil
+	}
+	switch op.text {
+	case "=~", "!~":
+		p.next()
+		v := p.next()
+		if v.kind != tokenString {
+			return nil, p.errorf(v, "expected a regular expression string but found %s", v)
+		}
+		re, err := regexp.Compile(v.value.(string))
+		if err != nil {
+			return nil, p.errorf(v, "%s", err)
+		}
+		return &compare{path: t.text, op: op.text, re: re}, nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.next()
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return &compare{path: t.text, op: op.text, values: []interface{}{v}}, nil
+	}
+	return &exists{t.text}, nil
+}
+
+func (p *parser) value() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString, tokenNumber:
+		return t.value, nil
+	case tokenPath:
+		switch t.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return nil, p.errorf(t, "expected a value but found %s", t)
+}
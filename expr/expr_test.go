@@ -0,0 +1,83 @@
+package expr
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	fields := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"level": "error",
+		"msg": "request failed: connection reset",
+		"http": {"status": 503, "method": "GET"},
+		"user": {"id": 42},
+		"tags": [{"name": "a"}, {"name": "b"}],
+		"k8s.pod": "foo-1",
+		"debug": false,
+		"trace": null
+	}`), &fields))
+	tests := []struct {
+		it   string
+		expr string
+		want bool
+	}{
+		{"compares strings", `level == "error"`, true},
+		{"compares single quoted strings", `level != 'error'`, false},
+		{"compares numbers", `http.status >= 500 && http.status < 600`, true},
+		{"compares numbers to numeric strings", `user.id == "42"`, true},
+		{"compares strings in order", `http.method < "POST"`, true},
+		{"does not order mismatched types", `http.method > 1`, false},
+		{"checks membership", `level in ("error", "warn")`, true},
+		{"checks membership of numbers", `http.status in (500, 502)`, false},
+		{"matches regular expressions", `msg =~ 'connection (reset|refused)'`, true},
+		{"negates regular expressions", `msg !~ "^request"`, false},
+		{"checks existence", `http.method && !http.path`, true},
+		{"treats missing fields as null", `http.path == null && trace == null && level != null`, true},
+		{"compares booleans", `debug == false`, true},
+		{"indexes arrays", `tags.1.name == "b"`, true},
+		{"looks up keys containing dots", `k8s.pod == "foo-1"`, true},
+		{"gives && precedence over ||", `level == "info" && user.id == 1 || http.status == 503`, true},
+		{"groups with parentheses", `level == "info" && (user.id == 1 || http.status == 503)`, false},
+		{"evaluates the example", `level in ("error","warn") && http.status >= 500 && user.id == "42"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.it, func(t *testing.T) {
+			e, err := Parse(tt.expr)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, e.Match(fields))
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{`level ==`, `invalid expression "level ==" at position 9: expected a value but found end of expression`},
+		{`level == "error`, `invalid expression "level == \"error" at position 10: unterminated string`},
+		{`(level`, `invalid expression "(level" at position 7: expected ")" but found end of expression`},
+		{`level # 1`, `invalid expression "level # 1" at position 7: unexpected character '#'`},
+		{`msg =~ "("`, "invalid expression \"msg =~ \\\"(\\\"\" at position 8: error parsing regexp: missing closing ): `(`"},
+		{`level in "error"`, `invalid expression "level in \"error\"" at position 10: expected "(" but found "\"error\""`},
+		{`level level`, `invalid expression "level level" at position 7: unexpected "level"`},
+	}
+	for _, tt := range tests {
+		t.Run("returns an error for "+tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	fields := map[string]interface{}{"a": map[string]interface{}{"b.c": 1.0}}
+	v, ok := Lookup(fields, "a.b.c")
+	require.True(t, ok)
+	require.Equal(t, 1.0, v)
+	_, ok = Lookup(fields, "a.b")
+	require.False(t, ok)
+}
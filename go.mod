@@ -8,6 +8,8 @@ require (
 	github.com/jwalton/go-supportscolor v1.1.0
 	github.com/mattn/go-isatty v0.0.14
 	github.com/ryantate13/hash-set v0.0.0-20220722010357-17646db176b9
+	github.com/stretchr/testify v1.8.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -15,9 +17,6 @@ require (
 	github.com/dlclark/regexp2 v1.7.0 // indirect
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/objx v0.4.0 // indirect
-	github.com/stretchr/testify v1.8.0 // indirect
 	golang.org/x/sys v0.0.0-20220721230656-c6bc011c0c49 // indirect
 	golang.org/x/term v0.0.0-20220526004731-065cf7ba2467 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
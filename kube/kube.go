@@ -0,0 +1,384 @@
+package kube
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/fn"
+	"github.com/ryantate13/klogs/logs"
+)
+
+// RetryInterval is how long to wait before listing pods again after a watch fails
+var RetryInterval = 2 * time.Second
+
+// Client is a logs.Backend that talks to the Kubernetes API directly, using the same kubeconfig, context and namespace
+// options as kubectl
+type Client struct {
+	opts      *args.Args
+	server    string
+	namespace string
+	http      *http.Client
+	config    *config
+}
+
+// New returns a Client for the cluster of the kubeconfig context selected by opts
+func New(opts *args.Args) (*Client, error) {
+	k, err := loadKubeConfig(fn.Coalesce(opts.KubeConfig, defaultKubeConfig()))
+	if err != nil {
+		return nil, err
+	}
+	c, err := k.resolve(opts.Context)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = c.tls
+	return &Client{
+		opts:      opts,
+		server:    c.server,
+		namespace: fn.Coalesce(opts.Namespace, c.namespace),
+		http:      &http.Client{Transport: transport},
+		config:    c,
+	}, nil
+}
+
+type status struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+type container struct {
+	Name string `json:"name"`
+}
+
+func (c container) name() string {
+	return c.Name
+}
+
+type pod struct {
+	Metadata struct {
+		Name            string            `json:"name"`
+		Namespace       string            `json:"namespace"`
+		ResourceVersion string            `json:"resourceVersion"`
+		Annotations     map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		InitContainers []container `json:"initContainers"`
+		Containers     []container `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+func (p *pod) pod() *logs.Pod {
+	return &logs.Pod{Name: p.Metadata.Name, Namespace: p.Metadata.Namespace, Phase: p.Status.Phase}
+}
+
+// containers returns the names of the containers whose logs should be streamed, following the same rules as kubectl
+func (p *pod) containers(opts *args.Args) []string {
+	if opts.Container != "" {
+		return []string{opts.Container}
+	}
+	containers := fn.Map(p.Spec.Containers, container.name)
+	if opts.AllContainers {
+		return append(fn.Map(p.Spec.InitContainers, container.name), containers...)
+	}
+	if c := p.Metadata.Annotations["kubectl.kubernetes.io/default-container"]; c != "" {
+		return []string{c}
+	}
+	if len(containers) > 1 {
+		return containers[:1]
+	}
+	return containers
+}
+
+type podList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []*pod `json:"items"`
+}
+
+type event struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// get sends a GET request to the API server, returning the response body if it succeeded
+func (c *Client) get(ctx context.Context, path string, query url.Values) (io.ReadCloser, error) {
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.config.token != nil {
+		token, err := c.config.token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.config.username != "" {
+		req.SetBasicAuth(c.config.username, c.config.password)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		s := &status{}
+		b, _ := io.ReadAll(res.Body)
+		if json.Unmarshal(b, s) != nil || s.Message == "" {
+			s.Message = strings.TrimSpace(string(b))
+		}
+		return nil, fmt.Errorf("GET %s: %s: %s", path, res.Status, s.Message)
+	}
+	return res.Body, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	body, err := c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+func (c *Client) podsPath() string {
+	if c.opts.AllNamespaces {
+		return "/api/v1/pods"
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(c.namespace) + "/pods"
+}
+
+func (c *Client) podsQuery() url.Values {
+	q := url.Values{}
+	if len(c.opts.Label) > 0 {
+		q.Set("labelSelector", strings.Join(c.opts.Label, ","))
+	}
+	return q
+}
+
+func (c *Client) list(ctx context.Context) (*podList, error) {
+	list := &podList{}
+	return list, c.getJSON(ctx, c.podsPath(), c.podsQuery(), list)
+}
+
+func (c *Client) Pods(ctx context.Context) ([]*logs.Pod, error) {
+	list, err := c.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fn.Map(list.Items, (*pod).pod), nil
+}
+
+// Watch lists pods and then watches them for changes, listing them again whenever the watch ends
+func (c *Client) Watch(ctx context.Context) (<-chan []*logs.Pod, error) {
+	list, err := c.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []*logs.Pod)
+	go func() {
+		defer close(ch)
+		for {
+			pods := map[string]*logs.Pod{}
+			for _, p := range list.Items {
+				pods[p.Metadata.Namespace+"/"+p.Metadata.Name] = p.pod()
+			}
+			send := func() bool {
+				snapshot := make([]*logs.Pod, 0, len(pods))
+				for _, p := range pods {
+					snapshot = append(snapshot, p)
+				}
+				select {
+				case ch <- snapshot:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			}
+			if !send() {
+				return
+			}
+			_ = c.watch(ctx, list.Metadata.ResourceVersion, func(t string, p *pod) bool {
+				key := p.Metadata.Namespace + "/" + p.Metadata.Name
+				if t == "DELETED" {
+					delete(pods, key)
+				} else {
+					pods[key] = p.pod()
+				}
+				return send()
+			})
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(RetryInterval):
+				}
+				if list, err = c.list(ctx); err == nil {
+					break
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// watch calls onEvent for each pod event until the watch ends or onEvent returns false
+func (c *Client) watch(ctx context.Context, resourceVersion string, onEvent func(string, *pod) bool) error {
+	q := c.podsQuery()
+	q.Set("watch", "1")
+	q.Set("resourceVersion", resourceVersion)
+	q.Set("allowWatchBookmarks", "true")
+	body, err := c.get(ctx, c.podsPath(), q)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	dec := json.NewDecoder(body)
+	for {
+		e := &event{}
+		if err = dec.Decode(e); err != nil {
+			return err
+		}
+		switch e.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			p := &pod{}
+			if err = json.Unmarshal(e.Object, p); err != nil {
+				return err
+			}
+			if !onEvent(e.Type, p) {
+				return nil
+			}
+		case "ERROR":
+			s := &status{}
+			_ = json.Unmarshal(e.Object, s)
+			return fmt.Errorf("watch failed: %d %s", s.Code, s.Message)
+		}
+	}
+}
+
+func (c *Client) logsQuery(container, sinceTime string) (url.Values, error) {
+	q := url.Values{}
+	q.Set("container", container)
+	q.Set("timestamps", "true")
+	for k, v := range map[string]bool{
+		"follow":   c.opts.Follow,
+		"previous": c.opts.Previous,
+	} {
+		if v {
+			q.Set(k, "true")
+		}
+	}
+	if c.opts.LimitBytes != "" {
+		q.Set("limitBytes", c.opts.LimitBytes)
+	}
+	if sinceTime != "" {
+		q.Set("sinceTime", sinceTime)
+		return q, nil
+	}
+	if c.opts.SinceTime != "" {
+		q.Set("sinceTime", c.opts.SinceTime)
+	}
+	if c.opts.Since != "" {
+		d, err := time.ParseDuration(c.opts.Since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since duration %q: %w", c.opts.Since, err)
+		}
+		q.Set("sinceSeconds", strconv.Itoa(int((d+time.Second-1)/time.Second)))
+	}
+	if c.opts.Tail != "" && c.opts.Tail != "-1" {
+		q.Set("tailLines", c.opts.Tail)
+	}
+	return q, nil
+}
+
+// Logs streams the logs of each of the pod's selected containers using the pod log subresource
+func (c *Client) Logs(ctx context.Context, errChan chan<- error, p *logs.Pod, sinceTime string) (<-chan string, error) {
+	podPath := "/api/v1/namespaces/" + url.PathEscape(p.Namespace) + "/pods/" + url.PathEscape(p.Name)
+	spec := &pod{}
+	if err := c.getJSON(ctx, podPath, nil, spec); err != nil {
+		return nil, err
+	}
+	containers := spec.containers(c.opts)
+	bodies := make([]io.ReadCloser, 0, len(containers))
+	for _, container := range containers {
+		q, err := c.logsQuery(container, sinceTime)
+		if err == nil {
+			var body io.ReadCloser
+			if body, err = c.get(ctx, podPath+"/log", q); err == nil {
+				bodies = append(bodies, body)
+				continue
+			}
+		}
+		for _, b := range bodies {
+			b.Close()
+		}
+		return nil, err
+	}
+	ch := make(chan string)
+	wg := &sync.WaitGroup{}
+	wg.Add(len(bodies))
+	errs := make([]error, len(bodies))
+	for i, body := range bodies {
+		go func(i int, body io.ReadCloser, prefix string) {
+			defer wg.Done()
+			defer body.Close()
+			errs[i] = readLines(body, func(line string) {
+				ch <- prefix + line
+			})
+		}(i, body, "[pod/"+p.Name+"/"+containers[i]+"] ")
+	}
+	go func() {
+		wg.Wait()
+		var err error
+		for _, e := range errs {
+			if err == nil {
+				err = e
+			}
+		}
+		errChan <- err
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// readLines calls send for each line read from r until it is exhausted, returning nil once the end is reached
+func readLines(r io.Reader, send func(string)) error {
+	rd := bufio.NewReader(r)
+	var buf strings.Builder
+	for {
+		chunk, isPrefix, err := rd.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		buf.Write(chunk)
+		if !isPrefix {
+			send(buf.String())
+			buf.Reset()
+		}
+	}
+}
+
+var _ logs.Backend = new(Client)
+var _ logs.Watcher = new(Client)
@@ -0,0 +1,275 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/logs"
+)
+
+const testPods = `{
+	"metadata": {"resourceVersion": "1"},
+	"items": [
+		{"metadata": {"name": "foo", "namespace": "test"}, "status": {"phase": "Running"}},
+		{"metadata": {"name": "bar", "namespace": "test"}, "status": {"phase": "Pending"}}
+	]
+}`
+
+const testPod = `{
+	"metadata": {"name": "foo", "namespace": "test"},
+	"spec": {"initContainers": [{"name": "init"}], "containers": [{"name": "app"}, {"name": "sidecar"}]},
+	"status": {"phase": "Running"}
+}`
+
+// fakeAPI stands in for the Kubernetes API server, recording the requests it receives
+func fakeAPI(t *testing.T, requests chan<- *http.Request) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests != nil {
+			requests <- r
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"kind":"Status","message":"Unauthorized","code":401}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/namespaces/test/pods":
+			if r.URL.Query().Get("watch") == "" {
+				fmt.Fprint(w, testPods)
+				return
+			}
+			enc := json.NewEncoder(w)
+			for _, e := range []string{
+				`{"type":"ADDED","object":{"metadata":{"name":"baz","namespace":"test"},"status":{"phase":"Running"}}}`,
+				`{"type":"DELETED","object":{"metadata":{"name":"foo","namespace":"test"}}}`,
+			} {
+				require.NoError(t, enc.Encode(json.RawMessage(e)))
+				w.(http.Flusher).Flush()
+			}
+			<-r.Context().Done()
+		case "/api/v1/namespaces/test/pods/foo":
+			fmt.Fprint(w, testPod)
+		case "/api/v1/namespaces/test/pods/foo/log":
+			c := r.URL.Query().Get("container")
+			fmt.Fprintf(w, "2022-11-09T12:00:00.000000001Z %s one\n2022-11-09T12:00:00.000000002Z %s two\n", c, c)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"kind":"Status","message":"not found","code":404}`)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func writeKubeConfig(t *testing.T, server string) string {
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte(`apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: `+server+`
+- name: other
+  cluster:
+    server: https://other.example.com
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: test
+- name: other
+  context:
+    cluster: other
+    user: missing
+users:
+- name: test
+  user:
+    token: secret
+`), 0600))
+	return path
+}
+
+func TestNew(t *testing.T) {
+	path := writeKubeConfig(t, "https://test.example.com/")
+	t.Run("uses the current context of the kubeconfig", func(t *testing.T) {
+		c, err := New(&args.Args{KubeConfig: path})
+		require.NoError(t, err)
+		require.Equal(t, "https://test.example.com", c.server)
+		require.Equal(t, "test", c.namespace)
+		token, err := c.config.token(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "secret", token)
+	})
+	t.Run("uses the context and namespace from the options", func(t *testing.T) {
+		c, err := New(&args.Args{KubeConfig: path, Context: "other", Namespace: "ns"})
+		require.NoError(t, err)
+		require.Equal(t, "https://other.example.com", c.server)
+		require.Equal(t, "ns", c.namespace)
+		require.Nil(t, c.config.token)
+	})
+	t.Run("merges a list of kubeconfig files", func(t *testing.T) {
+		missing := filepath.Join(t.TempDir(), "missing")
+		c, err := New(&args.Args{KubeConfig: missing + string(os.PathListSeparator) + path})
+		require.NoError(t, err)
+		require.Equal(t, "https://test.example.com", c.server)
+	})
+	t.Run("returns an error if the context does not exist", func(t *testing.T) {
+		_, err := New(&args.Args{KubeConfig: path, Context: "missing"})
+		require.Error(t, err)
+	})
+}
+
+func TestContexts(t *testing.T) {
+	path := writeKubeConfig(t, "https://test.example.com/")
+	for _, tt := range []struct {
+		it      string
+		context string
+		want    []string
+		err     string
+	}{
+		{it: "matches globs against the contexts of the kubeconfig", context: "t*, other", want: []string{"test", "other"}},
+		{it: "keeps contexts listed by name without duplicates", context: "other,missing,other", want: []string{"other", "missing"}},
+		{it: "returns an error if a glob matches no contexts", context: "prod-*", err: "no kubeconfig contexts match"},
+		{it: "returns an error for invalid globs", context: "[", err: "invalid context pattern"},
+	} {
+		t.Run(tt.it, func(t *testing.T) {
+			got, err := Contexts(&args.Args{KubeConfig: path, Context: tt.context})
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPods(t *testing.T) {
+	requests := make(chan *http.Request, 2)
+	s := fakeAPI(t, requests)
+	c, err := New(&args.Args{KubeConfig: writeKubeConfig(t, s.URL), Label: []string{"app=foo", "tier!=db"}})
+	require.NoError(t, err)
+	pods, err := c.Pods(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []*logs.Pod{
+		{Name: "foo", Namespace: "test", Phase: "Running"},
+		{Name: "bar", Namespace: "test", Phase: "Pending"},
+	}, pods)
+	require.Equal(t, "app=foo,tier!=db", (<-requests).URL.Query().Get("labelSelector"))
+
+	c.config.token = nil
+	_, err = c.Pods(context.Background())
+	require.EqualError(t, err, "GET /api/v1/namespaces/test/pods: 401 Unauthorized: Unauthorized")
+}
+
+func TestLogs(t *testing.T) {
+	tests := []struct {
+		it    string
+		opts  *args.Args
+		want  []string
+		query map[string]string
+	}{
+		{
+			it:   "streams the logs of the default container",
+			opts: &args.Args{Tail: "10", Since: "90s", Follow: true},
+			want: []string{
+				"[pod/foo/app] 2022-11-09T12:00:00.000000001Z app one",
+				"[pod/foo/app] 2022-11-09T12:00:00.000000002Z app two",
+			},
+			query: map[string]string{
+				"container":    "app",
+				"timestamps":   "true",
+				"follow":       "true",
+				"tailLines":    "10",
+				"sinceSeconds": "90",
+			},
+		},
+		{
+			it:   "streams the logs of all containers",
+			opts: &args.Args{AllContainers: true},
+			want: []string{
+				"[pod/foo/init] 2022-11-09T12:00:00.000000001Z init one",
+				"[pod/foo/init] 2022-11-09T12:00:00.000000002Z init two",
+				"[pod/foo/app] 2022-11-09T12:00:00.000000001Z app one",
+				"[pod/foo/app] 2022-11-09T12:00:00.000000002Z app two",
+				"[pod/foo/sidecar] 2022-11-09T12:00:00.000000001Z sidecar one",
+				"[pod/foo/sidecar] 2022-11-09T12:00:00.000000002Z sidecar two",
+			},
+		},
+		{
+			it:   "streams the logs of the selected container",
+			opts: &args.Args{Container: "sidecar", SinceTime: "2022-11-09T00:00:00Z"},
+			want: []string{
+				"[pod/foo/sidecar] 2022-11-09T12:00:00.000000001Z sidecar one",
+				"[pod/foo/sidecar] 2022-11-09T12:00:00.000000002Z sidecar two",
+			},
+			query: map[string]string{
+				"container": "sidecar",
+				"sinceTime": "2022-11-09T00:00:00Z",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.it, func(t *testing.T) {
+			requests := make(chan *http.Request, 10)
+			s := fakeAPI(t, requests)
+			tt.opts.KubeConfig = writeKubeConfig(t, s.URL)
+			c, err := New(tt.opts)
+			require.NoError(t, err)
+			errChan := make(chan error)
+			ch, err := c.Logs(context.Background(), errChan, &logs.Pod{Name: "foo", Namespace: "test"}, "")
+			require.NoError(t, err)
+			var got []string
+			go func() {
+				require.NoError(t, <-errChan)
+			}()
+			for l := range ch {
+				got = append(got, l)
+			}
+			require.ElementsMatch(t, tt.want, got)
+			<-requests
+			logsRequest := <-requests
+			for k, v := range tt.query {
+				require.Equal(t, v, logsRequest.URL.Query().Get(k), k)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := fakeAPI(t, nil)
+	c, err := New(&args.Args{KubeConfig: writeKubeConfig(t, s.URL)})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := c.Watch(ctx)
+	require.NoError(t, err)
+	for _, want := range [][]*logs.Pod{
+		{{Name: "foo", Namespace: "test", Phase: "Running"}, {Name: "bar", Namespace: "test", Phase: "Pending"}},
+		{
+			{Name: "foo", Namespace: "test", Phase: "Running"},
+			{Name: "bar", Namespace: "test", Phase: "Pending"},
+			{Name: "baz", Namespace: "test", Phase: "Running"},
+		},
+		{{Name: "bar", Namespace: "test", Phase: "Pending"}, {Name: "baz", Namespace: "test", Phase: "Running"}},
+	} {
+		select {
+		case got := <-ch:
+			require.ElementsMatch(t, want, got)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for pods")
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/fn"
+)
+
+type namedCluster struct {
+	Name    string `yaml:"name"`
+	Cluster struct {
+		Server                   string `yaml:"server"`
+		CertificateAuthority     string `yaml:"certificate-authority"`
+		CertificateAuthorityData string `yaml:"certificate-authority-data"`
+		InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		TLSServerName            string `yaml:"tls-server-name"`
+	} `yaml:"cluster"`
+}
+
+type namedUser struct {
+	Name string `yaml:"name"`
+	User struct {
+		ClientCertificate     string      `yaml:"client-certificate"`
+		ClientCertificateData string      `yaml:"client-certificate-data"`
+		ClientKey             string      `yaml:"client-key"`
+		ClientKeyData         string      `yaml:"client-key-data"`
+		Token                 string      `yaml:"token"`
+		TokenFile             string      `yaml:"tokenFile"`
+		Username              string      `yaml:"username"`
+		Password              string      `yaml:"password"`
+		Exec                  *execConfig `yaml:"exec"`
+		AuthProvider          *struct {
+			Name string `yaml:"name"`
+		} `yaml:"auth-provider"`
+	} `yaml:"user"`
+}
+
+type namedContext struct {
+	Name    string `yaml:"name"`
+	Context struct {
+		Cluster   string `yaml:"cluster"`
+		User      string `yaml:"user"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"context"`
+}
+
+type kubeConfig struct {
+	CurrentContext string         `yaml:"current-context"`
+	Clusters       []namedCluster `yaml:"clusters"`
+	Users          []namedUser    `yaml:"users"`
+	Contexts       []namedContext `yaml:"contexts"`
+	// dir is the directory of the file each entry was read from, used to resolve relative paths
+	dir map[string]string
+}
+
+// execConfig is the configuration of a client-go credential plugin
+type execConfig struct {
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args"`
+	APIVersion string   `yaml:"apiVersion"`
+	Env        []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+}
+
+// config is the connection information resolved from a kubeconfig context
+type config struct {
+	server    string
+	namespace string
+	tls       *tls.Config
+	username  string
+	password  string
+	token     func(context.Context) (string, error)
+}
+
+func defaultKubeConfig() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+// loadKubeConfig reads and merges a list of kubeconfig files separated by os.PathListSeparator. As with kubectl, the
+// first file to set a value wins
+func loadKubeConfig(paths string) (*kubeConfig, error) {
+	merged := &kubeConfig{dir: map[string]string{}}
+	for _, path := range filepath.SplitList(paths) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		k := &kubeConfig{}
+		if err = yaml.Unmarshal(b, k); err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+		}
+		dir := filepath.Dir(path)
+		if merged.CurrentContext == "" {
+			merged.CurrentContext = k.CurrentContext
+		}
+		for _, c := range k.Clusters {
+			if _, ok := merged.dir["cluster/"+c.Name]; !ok {
+				merged.Clusters = append(merged.Clusters, c)
+				merged.dir["cluster/"+c.Name] = dir
+			}
+		}
+		for _, u := range k.Users {
+			if _, ok := merged.dir["user/"+u.Name]; !ok {
+				merged.Users = append(merged.Users, u)
+				merged.dir["user/"+u.Name] = dir
+			}
+		}
+		for _, c := range k.Contexts {
+			if _, ok := merged.dir["context/"+c.Name]; !ok {
+				merged.Contexts = append(merged.Contexts, c)
+				merged.dir["context/"+c.Name] = dir
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Contexts returns the names of the kubeconfig contexts selected by the context option, a comma separated list of
+// context names and glob patterns such as "prod-*", without duplicates. Patterns match contexts in the order they are
+// listed in the kubeconfig, and a pattern that matches no context is an error
+func Contexts(opts *args.Args) ([]string, error) {
+	k, err := loadKubeConfig(fn.Coalesce(opts.KubeConfig, defaultKubeConfig()))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, pattern := range strings.Split(opts.Context, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if !strings.ContainsAny(pattern, "*?[") {
+			add(pattern)
+			continue
+		}
+		matched := false
+		for _, c := range k.Contexts {
+			ok, err := path.Match(pattern, c.Name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid context pattern %q: %w", pattern, err)
+			}
+			if ok {
+				add(c.Name)
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no kubeconfig contexts match %q", pattern)
+		}
+	}
+	return names, nil
+}
+
+// resolve returns the connection information for the named context, or the current context if name is empty
+func (k *kubeConfig) resolve(name string) (*config, error) {
+	if name == "" {
+		name = k.CurrentContext
+	}
+	if name == "" {
+		return nil, errors.New("no context given and kubeconfig has no current-context")
+	}
+	var (
+		ctx     *namedContext
+		cluster *namedCluster
+		user    *namedUser
+	)
+	for i := range k.Contexts {
+		if k.Contexts[i].Name == name {
+			ctx = &k.Contexts[i]
+		}
+	}
+	if ctx == nil {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", name)
+	}
+	for i := range k.Clusters {
+		if k.Clusters[i].Name == ctx.Context.Cluster {
+			cluster = &k.Clusters[i]
+		}
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster %q not found in kubeconfig", ctx.Context.Cluster)
+	}
+	for i := range k.Users {
+		if k.Users[i].Name == ctx.Context.User {
+			user = &k.Users[i]
+		}
+	}
+	c := &config{
+		server:    strings.TrimSuffix(cluster.Cluster.Server, "/"),
+		namespace: fn.Coalesce(ctx.Context.Namespace, "default"),
+		tls: &tls.Config{
+			InsecureSkipVerify: cluster.Cluster.InsecureSkipTLSVerify,
+			ServerName:         cluster.Cluster.TLSServerName,
+		},
+	}
+	clusterDir := k.dir["cluster/"+cluster.Name]
+	ca, err := readData(cluster.Cluster.CertificateAuthorityData, cluster.Cluster.CertificateAuthority, clusterDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate authority: %w", err)
+	}
+	if ca != nil {
+		c.tls.RootCAs = x509.NewCertPool()
+		if !c.tls.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid certificate authority for cluster %q", cluster.Name)
+		}
+	}
+	if user == nil {
+		return c, nil
+	}
+	u, userDir := user.User, k.dir["user/"+user.Name]
+	if u.AuthProvider != nil {
+		return nil, fmt.Errorf("auth provider %q of user %q is not supported, use the kubectl backend instead",
+			u.AuthProvider.Name, user.Name)
+	}
+	cert, err := readData(u.ClientCertificateData, u.ClientCertificate, userDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client certificate: %w", err)
+	}
+	key, err := readData(u.ClientKeyData, u.ClientKey, userDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client key: %w", err)
+	}
+	if cert != nil && key != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate for user %q: %w", user.Name, err)
+		}
+		c.tls.Certificates = []tls.Certificate{pair}
+	}
+	c.username, c.password = u.Username, u.Password
+	switch {
+	case u.Token != "":
+		token := u.Token
+		c.token = func(context.Context) (string, error) {
+			return token, nil
+		}
+	case u.TokenFile != "":
+		path := resolvePath(u.TokenFile, userDir)
+		c.token = func(context.Context) (string, error) {
+			b, err := os.ReadFile(path)
+			return strings.TrimSpace(string(b)), err
+		}
+	case u.Exec != nil:
+		if c.token, err = u.Exec.credentials(c.tls); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func resolvePath(path, dir string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// readData returns base64 decoded data if present, otherwise the contents of the file at path, if any
+func readData(data, path, dir string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if path != "" {
+		return os.ReadFile(resolvePath(path, dir))
+	}
+	return nil, nil
+}
+
+type execCredential struct {
+	Status struct {
+		Token                 string     `json:"token"`
+		ClientCertificateData string     `json:"clientCertificateData"`
+		ClientKeyData         string     `json:"clientKeyData"`
+		ExpirationTimestamp   *time.Time `json:"expirationTimestamp"`
+	} `json:"status"`
+}
+
+func (e *execConfig) run(ctx context.Context) (*execCredential, error) {
+	c := osexec.CommandContext(ctx, e.Command, e.Args...)
+	c.Env = os.Environ()
+	for _, env := range e.Env {
+		c.Env = append(c.Env, env.Name+"="+env.Value)
+	}
+	info, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": e.APIVersion,
+		"kind":       "ExecCredential",
+		"spec":       map[string]interface{}{"interactive": false},
+	})
+	c.Env = append(c.Env, "KUBERNETES_EXEC_INFO="+string(info))
+	stderr := bytes.NewBuffer(nil)
+	c.Stderr = stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credential plugin %s failed: %w: %s", e.Command, err, strings.TrimSpace(stderr.String()))
+	}
+	cred := &execCredential{}
+	if err = json.Unmarshal(out, cred); err != nil {
+		return nil, fmt.Errorf("credential plugin %s returned invalid output: %w", e.Command, err)
+	}
+	return cred, nil
+}
+
+// credentials runs the credential plugin, adding any client certificate it returns to the TLS config, and returns a
+// func that returns its token, running the plugin again whenever the token expires
+func (e *execConfig) credentials(t *tls.Config) (func(context.Context) (string, error), error) {
+	cred, err := e.run(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if cred.Status.ClientCertificateData != "" {
+		pair, err := tls.X509KeyPair([]byte(cred.Status.ClientCertificateData), []byte(cred.Status.ClientKeyData))
+		if err != nil {
+			return nil, fmt.Errorf("credential plugin %s returned an invalid client certificate: %w", e.Command, err)
+		}
+		t.Certificates = []tls.Certificate{pair}
+	}
+	mu := sync.Mutex{}
+	return func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if exp := cred.Status.ExpirationTimestamp; exp != nil && time.Now().After(exp.Add(-10*time.Second)) {
+			refreshed, err := e.run(ctx)
+			if err != nil {
+				return "", err
+			}
+			cred = refreshed
+		}
+		return cred.Status.Token, nil
+	}, nil
+}
@@ -0,0 +1,131 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ClusterErrors holds the errors listing the pods of some of the clusters of a Clusters backend by context. The pods
+// of the other clusters are listed regardless
+type ClusterErrors map[string]error
+
+func (e ClusterErrors) Error() string {
+	return strings.Join(e.messages(), "\n")
+}
+
+// messages returns a message for the error of each context, sorted by context
+func (e ClusterErrors) messages() []string {
+	contexts := make([]string, 0, len(e))
+	for c := range e {
+		contexts = append(contexts, c)
+	}
+	sort.Strings(contexts)
+	msgs := make([]string, len(contexts))
+	for i, c := range contexts {
+		msgs[i] = fmt.Sprintf("unable to list pods of context %s: %s", c, e[c].Error())
+	}
+	return msgs
+}
+
+type clusters struct {
+	contexts []string
+	backends map[string]Backend
+	mu       sync.Mutex
+	// listed holds the pods last listed for each context, which are listed again while listing fails so that their
+	// streams are kept
+	listed map[string][]*Pod
+	// failing holds the contexts listing pods last failed for, so that each failure is only reported once
+	failing map[string]bool
+}
+
+// Clusters returns a Backend that lists pods and streams their logs from the backend of each kubeconfig context in
+// parallel, setting the context of each pod. If listing pods fails for some contexts but not others, the pods of the
+// others are returned along with ClusterErrors for the contexts that newly failed
+func Clusters(backends map[string]Backend) Backend {
+	c := &clusters{backends: backends, listed: map[string][]*Pod{}, failing: map[string]bool{}}
+	for name := range backends {
+		c.contexts = append(c.contexts, name)
+	}
+	sort.Strings(c.contexts)
+	return c
+}
+
+func (c *clusters) Pods(ctx context.Context) ([]*Pod, error) {
+	var (
+		wg   sync.WaitGroup
+		pods = make([][]*Pod, len(c.contexts))
+		errs = make([]error, len(c.contexts))
+	)
+	for i, name := range c.contexts {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			pods[i], errs[i] = c.backends[name].Pods(ctx)
+		}(i, name)
+	}
+	wg.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var (
+		all    []*Pod
+		failed = ClusterErrors{}
+	)
+	for i, name := range c.contexts {
+		if errs[i] != nil {
+			if !c.failing[name] {
+				failed[name] = errs[i]
+			}
+			c.failing[name] = true
+			all = append(all, c.listed[name]...)
+			continue
+		}
+		delete(c.failing, name)
+		for _, p := range pods[i] {
+			p.Context = name
+		}
+		c.listed[name] = pods[i]
+		all = append(all, pods[i]...)
+	}
+	if len(c.failing) == len(c.contexts) && len(all) == 0 {
+		// without pods from any cluster, the failures aren't partial
+		failures := ClusterErrors{}
+		for i, name := range c.contexts {
+			failures[name] = errs[i]
+		}
+		return nil, errors.New(failures.Error())
+	}
+	if len(failed) > 0 {
+		return all, failed
+	}
+	return all, nil
+}
+
+func (c *clusters) Logs(ctx context.Context, errChan chan<- error, p *Pod, sinceTime string) (<-chan string, error) {
+	b, ok := c.backends[p.Context]
+	if !ok {
+		return nil, fmt.Errorf("unknown context %q", p.Context)
+	}
+	return b.Logs(ctx, errChan, p, sinceTime)
+}
+
+type failed struct {
+	err error
+}
+
+// Failed returns a Backend for a cluster that can't be connected to, whose pods can't be listed because of err. Passed
+// to Clusters, it reports err for its context while the clusters of the others are read
+func Failed(err error) Backend {
+	return &failed{err}
+}
+
+func (f *failed) Pods(context.Context) ([]*Pod, error) {
+	return nil, f.err
+}
+
+func (f *failed) Logs(context.Context, chan<- error, *Pod, string) (<-chan string, error) {
+	return nil, f.err
+}
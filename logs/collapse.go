@@ -0,0 +1,102 @@
+package logs
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ryantate13/klogs/args"
+)
+
+// collapser collapses runs of repeated lines from a container stream into a single entry
+type collapser struct {
+	// patterns compares the masked messages of entries rather than their lines, so lines differing only by numbers,
+	// ids and addresses are repeats
+	patterns bool
+	// quiet is how long a run is held back while following, waiting for more repeats, before it is sent
+	quiet time.Duration
+	// max is the number of repeats after which a run is sent, or 0 for no limit
+	max int
+}
+
+// newCollapser returns a collapser for the collapse options, or nil if repeated lines aren't collapsed
+func newCollapser(opts *args.Args) (*collapser, error) {
+	if !opts.Collapse && !opts.CollapsePatterns {
+		return nil, nil
+	}
+	c := &collapser{patterns: opts.CollapsePatterns}
+	var err error
+	if c.quiet, err = time.ParseDuration(opts.CollapseAfter); err != nil || c.quiet <= 0 {
+		return nil, mkError(map[string]interface{}{
+			"code":           "invalid_collapse",
+			"collapse-after": opts.CollapseAfter,
+			"error":          "expected a positive duration such as 500ms or 2s",
+		})
+	}
+	if opts.CollapseMax != "" {
+		if c.max, err = strconv.Atoi(opts.CollapseMax); err != nil || c.max < 0 {
+			return nil, mkError(map[string]interface{}{
+				"code":         "invalid_collapse",
+				"collapse-max": opts.CollapseMax,
+				"error":        "expected a number of repeats, or 0 for no limit",
+			})
+		}
+	}
+	return c, nil
+}
+
+// key returns what an entry is compared by to find repeats
+func (c *collapser) key(e *Entry) string {
+	if c.patterns {
+		return mask(e.Line)
+	}
+	return e.Line
+}
+
+// run is a run of repeated entries that may still have repeats to come. Its entry is the first of the run
+type run struct {
+	entry   *Entry
+	key     string
+	updated time.Time
+}
+
+// collapse counts an entry as a repeat of the pending run of its container stream, returning the run it replaced as
+// pending if it isn't, or the run itself if it has reached the maximum number of repeats
+func (ps *podStream) collapse(c *collapser, e *Entry) *Entry {
+	key := streamKey(e)
+	pending, ok := ps.runs[key]
+	if ok && pending.key == c.key(e) {
+		pending.entry.Repeated++
+		pending.updated = time.Now()
+		if c.max > 0 && pending.entry.Repeated >= c.max {
+			delete(ps.runs, key)
+			return pending.entry
+		}
+		return nil
+	}
+	e.Repeated = 1
+	ps.runs[key] = &run{entry: e, key: c.key(e), updated: time.Now()}
+	if !ok {
+		return nil
+	}
+	return pending.entry
+}
+
+// expireRuns removes and returns the pending runs that haven't been repeated for the quiet interval as of now, or all
+// of them if now is zero, along with how long until the next one expires
+func (ps *podStream) expireRuns(c *collapser, now time.Time) ([]*Entry, time.Duration) {
+	var (
+		expired []*Entry
+		next    time.Duration
+	)
+	for key, pending := range ps.runs {
+		wait := pending.updated.Add(c.quiet).Sub(now)
+		if now.IsZero() || wait <= 0 {
+			expired = append(expired, pending.entry)
+			delete(ps.runs, key)
+		} else if next == 0 || wait < next {
+			next = wait
+		}
+	}
+	sortByTime(expired)
+	return expired, next
+}
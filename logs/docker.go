@@ -0,0 +1,157 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/exec"
+	"github.com/ryantate13/klogs/fn"
+)
+
+const (
+	// composeProject is the label docker compose sets to the project of a container, which is used as its namespace
+	composeProject = "com.docker.compose.project"
+	// composeService is the label docker compose sets to the service of a container, used as its container name
+	composeService = "com.docker.compose.service"
+)
+
+type docker struct {
+	opts *args.Args
+	ex   exec.Executor
+	mu   sync.Mutex
+	// services maps the key of each container listed to the container name its lines are prefixed with
+	services map[string]string
+}
+
+// Docker returns a Backend that shells out to docker using the given Executor. Each container is listed as a pod named
+// after the container, in the namespace of its docker compose project and with its compose service as its container
+func Docker(opts *args.Args, ex exec.Executor) Backend {
+	return &docker{opts: opts, ex: ex, services: map[string]string{}}
+}
+
+// parseLabels parses the labels of a container as listed by docker ps, formatted as "k=v,k=v". Commas in values are
+// kept with the value they are part of
+func parseLabels(s string) map[string]string {
+	labels := map[string]string{}
+	var last string
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok && last != "" {
+			labels[last] += "," + kv
+			continue
+		}
+		labels[k], last = v, k
+	}
+	return labels
+}
+
+// selects reports whether labels match a label selector of comma separated "k=v", "k==v", "k!=v", "k" and "!k"
+// requirements, like kubectl -l
+func selects(labels map[string]string, selector string) bool {
+	return fn.Reduce(strings.Split(selector, ","), func(a bool, req string) bool {
+		req = strings.TrimSpace(req)
+		if k, v, ok := strings.Cut(req, "!="); ok {
+			return a && labels[strings.TrimSpace(k)] != strings.TrimSpace(v)
+		}
+		if k, v, ok := strings.Cut(strings.Replace(req, "==", "=", 1), "="); ok {
+			value, exists := labels[strings.TrimSpace(k)]
+			return a && exists && value == strings.TrimSpace(v)
+		}
+		if strings.HasPrefix(req, "!") {
+			_, exists := labels[strings.TrimPrefix(req, "!")]
+			return a && !exists
+		}
+		_, exists := labels[req]
+		return a && exists
+	}, true)
+}
+
+// phases maps the states of docker containers to the pod phases they correspond to
+var phases = map[string]string{"running": "Running", "created": "Pending", "restarting": "Pending"}
+
+// Pods lists the containers matching the label options, in the docker compose project of the namespace option if set
+func (d *docker) Pods(ctx context.Context) ([]*Pod, error) {
+	ps := []string{"docker", "ps", "--all", "--no-trunc", "--format", "{{.Names}}\t{{.State}}\t{{.Labels}}"}
+	containers, err := d.ex.Sync(ctx, ps...)
+	if err != nil {
+		return nil, mkError(map[string]interface{}{
+			"code":    "get_pods_error",
+			"command": ps,
+			"error":   err.Error(),
+		})
+	}
+	services := map[string]string{}
+	var pods []*Pod
+	for _, c := range containers {
+		f := strings.SplitN(c, "\t", 3)
+		if len(f) < 2 || f[0] == "" {
+			continue
+		}
+		var labels map[string]string
+		if len(f) == 3 {
+			labels = parseLabels(f[2])
+		}
+		if !fn.Reduce(d.opts.Label, func(a bool, l string) bool {
+			return a && selects(labels, l)
+		}, true) {
+			continue
+		}
+		p := &Pod{Name: strings.Split(f[0], ",")[0], Namespace: labels[composeProject], Phase: f[1]}
+		if phase, ok := phases[f[1]]; ok {
+			p.Phase = phase
+		}
+		if d.opts.Namespace != "" && !d.opts.AllNamespaces && p.Namespace != d.opts.Namespace {
+			continue
+		}
+		service := fn.Coalesce(labels[composeService], p.Name)
+		if d.opts.Container != "" && service != d.opts.Container {
+			continue
+		}
+		services[p.key()] = service
+		pods = append(pods, p)
+	}
+	d.mu.Lock()
+	d.services = services
+	d.mu.Unlock()
+	return pods, nil
+}
+
+// Logs runs docker logs for a container, prefixing each line like kubectl logs --prefix does. The command is run by sh
+// so that the lines the container wrote to stderr, which docker logs writes to its own stderr, are streamed too. If
+// sinceTime is set, it replaces any since, since-time or tail options so that a reconnected stream resumes where the
+// previous one left off
+func (d *docker) Logs(ctx context.Context, errChan chan<- error, p *Pod, sinceTime string) (<-chan string, error) {
+	logCmd := []string{"sh", "-c", `exec "$@" 2>&1`, "sh", "docker", "logs", "--timestamps"}
+	if d.opts.Follow {
+		logCmd = append(logCmd, "--follow")
+	}
+	since, tail := fn.Coalesce(d.opts.SinceTime, d.opts.Since), d.opts.Tail
+	if sinceTime != "" {
+		since, tail = sinceTime, ""
+	}
+	if since != "" {
+		logCmd = append(logCmd, "--since", since)
+	}
+	if tail != "" {
+		logCmd = append(logCmd, "--tail", tail)
+	}
+	logCmd = append(logCmd, p.Name)
+	c, err := d.ex.Stream(ctx, errChan, logCmd...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", strings.Join(logCmd[4:], " "), err)
+	}
+	d.mu.Lock()
+	prefix := "[pod/" + p.Name + "/" + fn.Coalesce(d.services[p.key()], p.Name) + "] "
+	d.mu.Unlock()
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for line := range c {
+			ch <- prefix + line
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,97 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// findJSON locates the first JSON object embedded in a line, such as one logged after a plain text prefix, returning
+// its fields and [start, end) byte offsets
+func findJSON(line string) (map[string]interface{}, []int) {
+	for i := strings.IndexByte(line, '{'); i >= 0; {
+		dec := json.NewDecoder(strings.NewReader(line[i:]))
+		fields := map[string]interface{}{}
+		if dec.Decode(&fields) == nil {
+			return fields, []int{i, i + int(dec.InputOffset())}
+		}
+		next := strings.IndexByte(line[i+1:], '{')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return nil, nil
+}
+
+// unwrapJSON replaces string values that contain escaped JSON objects or arrays with the JSON itself, recursively,
+// returning the rewritten JSON and whether anything was unwrapped
+func unwrapJSON(s string) (string, bool) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	var (
+		out     strings.Builder
+		written int
+		changed bool
+		// objects tracks whether each enclosing value is an object and key whether the next token is an object key
+		objects []bool
+		key     bool
+	)
+	for {
+		prev := int(dec.InputOffset())
+		t, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			objects, key = append(objects, t == json.Delim('{')), t == json.Delim('{')
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			objects = objects[:len(objects)-1]
+			key = len(objects) > 0 && objects[len(objects)-1]
+			continue
+		}
+		isKey := key
+		key = !key && len(objects) > 0 && objects[len(objects)-1]
+		v, ok := t.(string)
+		trimmed := strings.TrimSpace(v)
+		if isKey || !ok || !(strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) ||
+			!json.Valid([]byte(trimmed)) {
+			continue
+		}
+		start := prev + strings.IndexByte(s[prev:], '"')
+		end := int(dec.InputOffset())
+		inner, _ := unwrapJSON(trimmed)
+		b := bytes.NewBuffer(nil)
+		if json.Compact(b, []byte(inner)) != nil {
+			continue
+		}
+		out.WriteString(s[written:start])
+		out.Write(b.Bytes())
+		written, changed = end, true
+	}
+	if !changed {
+		return s, false
+	}
+	out.WriteString(s[written:])
+	return out.String(), true
+}
+
+// unwrap rewrites a JSON entry with escaped JSON string values unwrapped, parsing its fields again
+func unwrap(e *Entry) {
+	if e.Format != FormatJSON {
+		return
+	}
+	start, end := e.JSONSpan[0], e.JSONSpan[1]
+	unwrapped, ok := unwrapJSON(e.Line[start:end])
+	if !ok {
+		return
+	}
+	fields := map[string]interface{}{}
+	if json.Unmarshal([]byte(unwrapped), &fields) != nil {
+		return
+	}
+	e.Line = e.Line[:start] + unwrapped + e.Line[end:]
+	e.Fields, e.JSONSpan = fields, []int{start, start + len(unwrapped)}
+	e.Level, e.LevelSpan = DetectLevel(e.Line, e.Fields)
+}
@@ -0,0 +1,116 @@
+package logs
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Entry is a single log entry read from a container, or a notice from klogs about the pods being streamed
+type Entry struct {
+	Pod       string
+	Namespace string
+	Container string
+	// Context is the kubeconfig context of the cluster the entry was read from, when reading from several clusters
+	Context string
+	// Line is the log entry as written by the container, without the prefix or timestamp added by the backend
+	Line string
+	// Time is when the entry was written, as recorded by the container runtime
+	Time time.Time
+	// Fields holds the log entry parsed as a JSON object or as logfmt, or nil if it is neither
+	Fields map[string]interface{}
+	// Format is the format Fields were parsed from, either FormatJSON or FormatLogfmt
+	Format string
+	// JSONSpan holds the [start, end) byte offsets of the JSON object in Line if Format is FormatJSON. It only covers
+	// part of the line for JSON logged after a plain text prefix
+	JSONSpan []int
+	// Record holds the log entry normalized from the schema of its logging library, or nil if it isn't JSON
+	Record *Record
+	// Level is the severity of the entry, if it could be detected
+	Level Level
+	// LevelSpan holds the [start, end) byte offsets of the level in Line, or nil if it could not be located
+	LevelSpan []int
+	// Matches holds the [start, end) byte offsets of the parts of Line matched by include patterns
+	Matches [][]int
+	// Stream is the output stream the entry was written to, either "stdout" or "stderr", if the backend reports it
+	Stream string
+	// Pattern is the template of the entry's message with its variable parts masked, if patterns are being clustered
+	Pattern string
+	// NewPattern is true if the entry is the first to match its pattern
+	NewPattern bool
+	// Repeated is the number of consecutive times the entry was written to its container stream, if repeated lines are
+	// collapsed
+	Repeated int
+	// Notice is true for entries generated by klogs, such as pods being added or streams being reconnected
+	Notice bool
+}
+
+// Embedded reports whether the entry is a JSON object logged after or before other text
+func (e *Entry) Embedded() bool {
+	return e.Format == FormatJSON && len(e.JSONSpan) == 2 && (e.JSONSpan[0] > 0 || e.JSONSpan[1] < len(e.Line))
+}
+
+// Continuation returns the lines grouped onto the first line of a multi-line entry, or an empty string otherwise
+func (e *Entry) Continuation() string {
+	_, rest, _ := strings.Cut(e.Line, "\n")
+	return rest
+}
+
+// split separates a line from a Backend into its prefix, timestamp and log entry
+func split(line string) (prefix, timestamp, logEntry string) {
+	prefix, logEntry, _ = strings.Cut(line, " ")
+	timestamp, logEntry, _ = strings.Cut(logEntry, " ")
+	return prefix, timestamp, logEntry
+}
+
+// source returns the container and output stream named by a "[pod/<name>/<container>]" or
+// "[pod/<name>/<container>/<stream>]" prefix, or empty strings if there are none
+func source(prefix string) (container, stream string) {
+	parts := strings.Split(strings.Trim(prefix, "[]"), "/")
+	if len(parts) == 4 {
+		stream = parts[3]
+	}
+	if len(parts) >= 3 {
+		container = parts[2]
+	}
+	return container, stream
+}
+
+// parse converts a line from a Backend, formatted as "[pod/<name>/<container>] <timestamp> <log entry>", into an Entry.
+// The prefix may also name the output stream, as "[pod/<name>/<container>/<stream>]"
+func parse(p *Pod, line string) *Entry {
+	prefix, timestamp, logEntry := split(line)
+	e := &Entry{Pod: p.Name, Namespace: p.Namespace, Context: p.Context, Line: logEntry}
+	e.Container, e.Stream = source(prefix)
+	e.Time, _ = time.Parse(time.RFC3339Nano, timestamp)
+	if e.Fields = parseJSON(logEntry); e.Fields != nil {
+		e.Format, e.JSONSpan = FormatJSON, []int{0, len(logEntry)}
+	} else if e.Fields = parseLogfmt(logEntry); e.Fields != nil {
+		e.Format = FormatLogfmt
+	} else if e.Fields, e.JSONSpan = findJSON(logEntry); e.Fields != nil {
+		e.Format = FormatJSON
+	}
+	if e.Format == FormatJSON {
+		e.Level, e.LevelSpan = DetectLevel(logEntry, e.Fields)
+	} else {
+		e.Level, e.LevelSpan = DetectLevel(logEntry, nil)
+	}
+	return e
+}
+
+// parseJSON returns the fields of a JSON object, or nil if s is not one
+func parseJSON(s string) map[string]interface{} {
+	if !strings.HasPrefix(strings.TrimSpace(s), "{") {
+		return nil
+	}
+	fields := map[string]interface{}{}
+	if json.Unmarshal([]byte(s), &fields) != nil {
+		return nil
+	}
+	return fields
+}
@@ -0,0 +1,113 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ryantate13/klogs/args"
+)
+
+// Stdin is the name of the pod that lines read from stdin are shown as
+const Stdin = "stdin"
+
+type files struct {
+	opts  *args.Args
+	stdin io.Reader
+}
+
+// Files returns a Backend that reads the files of opts.File instead of a cluster, reading stdin for "-". Each file is
+// listed as a pod named after its path, whose logs are the lines of the file
+func Files(opts *args.Args, stdin io.Reader) Backend {
+	return &files{opts, stdin}
+}
+
+// name returns the name of the pod a file is shown as
+func (f *files) name(path string) string {
+	if path == "-" {
+		return Stdin
+	}
+	return path
+}
+
+// Pods lists a pod for each file, returning an error if any of them can't be read
+func (f *files) Pods(context.Context) ([]*Pod, error) {
+	var pods []*Pod
+	for _, path := range f.opts.File {
+		if path != "-" {
+			if _, err := os.Stat(path); err != nil {
+				return nil, mkError(map[string]interface{}{
+					"code":  "file_error",
+					"file":  path,
+					"error": err.Error(),
+				})
+			}
+		}
+		pods = append(pods, &Pod{Name: f.name(path), Phase: "Succeeded"})
+	}
+	return pods, nil
+}
+
+// Logs streams the lines of the file a pod was listed for, formatted like the lines of other backends. Lines starting
+// with an RFC3339 timestamp keep it as their timestamp
+func (f *files) Logs(ctx context.Context, errChan chan<- error, p *Pod, _ string) (<-chan string, error) {
+	var r io.Reader
+	for _, path := range f.opts.File {
+		if f.name(path) != p.Name {
+			continue
+		}
+		if path == "-" {
+			r = f.stdin
+			break
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r = file
+		break
+	}
+	if r == nil {
+		return nil, os.ErrNotExist
+	}
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		if c, ok := r.(io.Closer); ok && r != f.stdin {
+			defer c.Close()
+		}
+		rd := bufio.NewReader(r)
+		for {
+			line, err := rd.ReadString('\n')
+			if line != "" {
+				select {
+				case ch <- fileLine(strings.TrimRight(line, "\r\n")):
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				errChan <- err
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// fileLine formats a line read from a file as "[file] <timestamp> <log entry>", with an empty timestamp unless the line
+// starts with one
+func fileLine(line string) string {
+	if timestamp, logEntry, ok := strings.Cut(line, " "); ok {
+		if _, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			return "[file] " + timestamp + " " + logEntry
+		}
+	}
+	return "[file]  " + line
+}
@@ -0,0 +1,120 @@
+package logs
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/expr"
+)
+
+// filter decides which entries are sent based on the line filtering options
+type filter struct {
+	include, exclude []*regexp.Regexp
+	where            *expr.Expr
+	keepNonJSON      bool
+	level            Level
+}
+
+// compile compiles regular expressions, returning an error with the given code for the first invalid one
+func compile(code string, patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, mkError(map[string]interface{}{
+				"code":    code,
+				"pattern": p,
+				"error":   err.Error(),
+			})
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+func newFilter(opts *args.Args) (*filter, error) {
+	include, err := compile("invalid_filter", opts.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compile("invalid_filter", opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	f := &filter{include: include, exclude: exclude, keepNonJSON: opts.KeepNonJSON}
+	if opts.Level != "" {
+		var ok bool
+		if f.level, ok = ParseLevel(opts.Level); !ok {
+			return nil, mkError(map[string]interface{}{
+				"code":  "invalid_filter",
+				"level": opts.Level,
+				"error": "unknown level, expected one of trace, debug, info, warn, error or fatal",
+			})
+		}
+	}
+	if opts.Where != "" {
+		if f.where, err = expr.Parse(opts.Where); err != nil {
+			return nil, mkError(map[string]interface{}{
+				"code":       "invalid_filter",
+				"expression": opts.Where,
+				"error":      err.Error(),
+			})
+		}
+	}
+	return f, nil
+}
+
+// match reports whether an entry should be sent. Lines must match one of the include patterns, if any, and none of the
+// exclude patterns, JSON entries must satisfy the where expression, if any, and entries must be at or above the minimum
+// level, if any. The parts of the line matched by include patterns are recorded in the entry's Matches
+func (f *filter) match(e *Entry) bool {
+	if e.Level < f.level {
+		return false
+	}
+	if f.where != nil {
+		if e.Fields == nil {
+			if !f.keepNonJSON {
+				return false
+			}
+		} else if !f.where.Match(e.Fields) {
+			return false
+		}
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(e.Line) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	var matches [][]int
+	for _, re := range f.include {
+		matches = append(matches, re.FindAllStringIndex(e.Line, -1)...)
+	}
+	if len(matches) == 0 {
+		return false
+	}
+	e.Matches = mergeRanges(matches)
+	return true
+}
+
+// mergeRanges sorts a list of [start, end) ranges and merges any that overlap
+func mergeRanges(ranges [][]int) [][]int {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i][0] < ranges[j][0]
+	})
+	merged := [][]int{ranges[0]}
+	for _, r := range ranges[1:] {
+		last := merged[len(merged)-1]
+		if r[0] <= last[1] {
+			if r[1] > last[1] {
+				last[1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
@@ -0,0 +1,109 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/exec"
+	"github.com/ryantate13/klogs/fn"
+)
+
+type kubectl struct {
+	opts *args.Args
+	ex   exec.Executor
+}
+
+// Kubectl returns a Backend that shells out to kubectl using the given Executor. The kubectl, kubectl-prefix and
+// kubectl-flag options replace the kubectl binary, for kubectl compatible tools like oc, run it by another command, and
+// add global flags to each command
+func Kubectl(opts *args.Args, ex exec.Executor) Backend {
+	return &kubectl{opts, ex}
+}
+
+// cmd returns a kubectl command, run with the configured binary, command prefix and global flags. The binary is a
+// single path, which may contain spaces, while the prefix is split into words
+func (k *kubectl) cmd(cmdAndArgs ...string) []string {
+	kubectl := append(strings.Fields(k.opts.KubectlPrefix), fn.Coalesce(k.opts.Kubectl, "kubectl"))
+	for _, f := range k.opts.KubectlFlags {
+		// a flag and its value may be passed together, separated by a space
+		if flag, value, ok := strings.Cut(strings.TrimSpace(f), " "); ok {
+			kubectl = append(kubectl, flag, strings.TrimSpace(value))
+		} else {
+			kubectl = append(kubectl, flag)
+		}
+	}
+	for k, v := range map[string]string{"--kubeconfig": k.opts.KubeConfig, "--context": k.opts.Context} {
+		if v != "" {
+			kubectl = append(kubectl, k, v)
+		}
+	}
+	return append(kubectl, cmdAndArgs...)
+}
+
+func (k *kubectl) Pods(ctx context.Context) ([]*Pod, error) {
+	getPods := k.cmd("get", "pods", "-o", "custom-columns=:metadata.name,:metadata.namespace,:status.phase")
+	if k.opts.AllNamespaces {
+		getPods = append(getPods, "--all-namespaces")
+	} else if k.opts.Namespace != "" {
+		getPods = append(getPods, "--namespace", k.opts.Namespace)
+	}
+	for _, l := range k.opts.Label {
+		getPods = append(getPods, "-l", l)
+	}
+	nsPods, err := k.ex.Sync(ctx, getPods...)
+	if err != nil {
+		return nil, mkError(map[string]interface{}{
+			"code":    "get_pods_error",
+			"command": getPods,
+			"error":   err.Error(),
+		})
+	}
+	return fn.Map(fn.Filter(nsPods, func(s string) bool {
+		return len(strings.Fields(s)) >= 2
+	}), func(s string) *Pod {
+		f := strings.Fields(s)
+		p := &Pod{Name: f[0], Namespace: f[1]}
+		if len(f) > 2 {
+			p.Phase = f[2]
+		}
+		return p
+	}), nil
+}
+
+// Logs runs kubectl logs for a pod. If sinceTime is set, it replaces any since, since-time or tail options so that a
+// reconnected stream resumes where the previous one left off
+func (k *kubectl) Logs(ctx context.Context, errChan chan<- error, p *Pod, sinceTime string) (<-chan string, error) {
+	logCmd := k.cmd("logs", "--prefix", "--timestamps")
+	for k, v := range map[string]bool{
+		"--all-containers": k.opts.AllContainers,
+		"--follow":         k.opts.Follow,
+		"--previous":       k.opts.Previous,
+	} {
+		if v {
+			logCmd = append(logCmd, k)
+		}
+	}
+	options := map[string]string{
+		"--container":   k.opts.Container,
+		"--limit-bytes": k.opts.LimitBytes,
+		"--since":       k.opts.Since,
+		"--since-time":  k.opts.SinceTime,
+		"--tail":        k.opts.Tail,
+	}
+	if sinceTime != "" {
+		options["--since"], options["--since-time"], options["--tail"] = "", sinceTime, ""
+	}
+	for k, v := range options {
+		if v != "" {
+			logCmd = append(logCmd, k, v)
+		}
+	}
+	logCmd = append(logCmd, "-n", p.Namespace, p.Name)
+	c, err := k.ex.Stream(ctx, errChan, logCmd...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", strings.Join(logCmd, " "), err)
+	}
+	return c, nil
+}
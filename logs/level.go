@@ -0,0 +1,122 @@
+package logs
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	LevelUnknown Level = iota
+	LevelTrace
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+var levelNames = map[Level]string{
+	LevelTrace: "trace",
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+	LevelFatal: "fatal",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the level for a level name as written by common logging libraries, ignoring case
+func ParseLevel(s string) (Level, bool) {
+	switch strings.ToLower(s) {
+	case "trace", "trc", "verbose", "vrb":
+		return LevelTrace, true
+	case "debug", "dbg", "d":
+		return LevelDebug, true
+	case "info", "inf", "information", "notice", "i":
+		return LevelInfo, true
+	case "warn", "warning", "wrn", "w":
+		return LevelWarn, true
+	case "error", "err", "eror", "e":
+		return LevelError, true
+	case "fatal", "panic", "dpanic", "critical", "crit", "alert", "emergency", "emerg", "ftl", "f":
+		return LevelFatal, true
+	}
+	return LevelUnknown, false
+}
+
+// numericLevel converts the numeric levels used by pino and bunyan
+func numericLevel(n float64) Level {
+	switch {
+	case n >= 60:
+		return LevelFatal
+	case n >= 50:
+		return LevelError
+	case n >= 40:
+		return LevelWarn
+	case n >= 30:
+		return LevelInfo
+	case n >= 20:
+		return LevelDebug
+	}
+	return LevelTrace
+}
+
+// levelKeys are the JSON and logfmt keys that hold the level of an entry, in order of preference
+var levelKeys = []string{"level", "severity", "lvl"}
+
+var (
+	jsonLevel = func() map[string]*regexp.Regexp {
+		res := map[string]*regexp.Regexp{}
+		for _, k := range levelKeys {
+			res[k] = regexp.MustCompile(`"` + k + `"\s*:\s*"?([^",}\s]+)`)
+		}
+		return res
+	}()
+	logfmtLevel = regexp.MustCompile(`(?:^|\s)(?:level|severity|lvl)="?(\w+)`)
+	klogHeader  = regexp.MustCompile(`^([IWEF])\d{4} \d{2}:\d{2}:\d{2}`)
+	textLevel   = regexp.MustCompile(`\b(TRACE|DEBUG|INFO|WARN|WARNING|ERROR|FATAL|PANIC|CRITICAL)\b`)
+)
+
+// DetectLevel finds the level of a log line from its JSON fields, falling back to its logfmt level key, klog header or
+// an upper case level name in plain text. It also returns the [start, end) byte offsets of the level in the line, or
+// nil if the level could not be located
+func DetectLevel(line string, fields map[string]interface{}) (Level, []int) {
+	if fields != nil {
+		for _, k := range levelKeys {
+			var l Level
+			switch v := fields[k].(type) {
+			case string:
+				l, _ = ParseLevel(v)
+			case float64:
+				l = numericLevel(v)
+			}
+			if l == LevelUnknown {
+				continue
+			}
+			if m := jsonLevel[k].FindStringSubmatchIndex(line); m != nil {
+				return l, m[2:4]
+			}
+			return l, nil
+		}
+	}
+	if m := logfmtLevel.FindStringSubmatchIndex(line); m != nil {
+		if l, ok := ParseLevel(line[m[2]:m[3]]); ok {
+			return l, m[2:4]
+		}
+	}
+	if m := klogHeader.FindStringSubmatchIndex(line); m != nil {
+		l, _ := ParseLevel(line[m[2]:m[3]])
+		return l, []int{m[0], m[0] + 5}
+	}
+	if m := textLevel.FindStringIndex(line); m != nil {
+		l, _ := ParseLevel(line[m[0]:m[1]])
+		return l, m
+	}
+	return LevelUnknown, nil
+}
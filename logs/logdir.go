@@ -0,0 +1,388 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ryantate13/klogs/args"
+)
+
+// FilePollInterval is how often followed log files are checked for new lines, rotation and container restarts
+var FilePollInterval = 250 * time.Millisecond
+
+var (
+	// podDir matches the directories of pods in /var/log/pods, named <namespace>_<pod>_<uid>
+	podDir = regexp.MustCompile(`^([^_]+)_([^_]+)_[^_]+$`)
+	// criLog matches the files in the container directories of /var/log/pods, named <restart count>.log, with a
+	// timestamp suffix once rotated
+	criLog = regexp.MustCompile(`^\d+\.log(\.\d{8}-\d{6})?$`)
+	// containerLog matches the files of /var/log/containers, named <pod>_<namespace>_<container>-<container id>.log
+	containerLog = regexp.MustCompile(`^([^_]+)_([^_]+)_(.+)-[0-9a-f]{64}\.log$`)
+	// dockerLog matches the files of /var/lib/docker/containers, named <container id>-json.log, with a .N suffix once
+	// rotated
+	dockerLog = regexp.MustCompile(`^([0-9a-f]{64})-json\.log(\.\d+)?$`)
+)
+
+// logContainer is a container whose log files were found in a log directory
+type logContainer struct {
+	namespace, pod, name string
+	// dir is the directory the files of the container are in. Files of the same container found in other directories,
+	// such as the links of /var/log/containers to /var/log/pods, are ignored
+	dir string
+}
+
+func (c *logContainer) key() string {
+	return c.namespace + "/" + c.pod + "/" + c.name
+}
+
+// identify returns the container a log file belongs to, derived from its path, or false if it isn't a log file
+func identify(path string) (*logContainer, bool) {
+	name, dir := filepath.Base(path), filepath.Dir(path)
+	if criLog.MatchString(name) {
+		if m := podDir.FindStringSubmatch(filepath.Base(filepath.Dir(dir))); m != nil {
+			return &logContainer{namespace: m[1], pod: m[2], name: filepath.Base(dir), dir: dir}, true
+		}
+	}
+	if m := containerLog.FindStringSubmatch(name); m != nil {
+		return &logContainer{namespace: m[2], pod: m[1], name: m[3], dir: dir}, true
+	}
+	if m := dockerLog.FindStringSubmatch(name); m != nil {
+		return &logContainer{pod: m[1][:12], dir: dir}, true
+	}
+	return nil, false
+}
+
+type logDir struct {
+	opts       *args.Args
+	mu         sync.Mutex
+	containers map[string][]*logContainer
+}
+
+// LogDir returns a Backend that reads the CRI and Docker json-file log files in the directory tree of opts.LogDir
+// instead of a cluster, such as /var/log/pods, /var/log/containers or /var/lib/docker/containers. Namespaces, pods and
+// containers are derived from the file paths
+func LogDir(opts *args.Args) Backend {
+	return &logDir{opts: opts, containers: map[string][]*logContainer{}}
+}
+
+// scan returns the containers with log files in the directory tree, filtered by the namespace and container options
+func (d *logDir) scan() ([]*logContainer, error) {
+	root, err := filepath.EvalSymlinks(d.opts.LogDir)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]*logContainer{}
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		c, ok := identify(path)
+		if !ok {
+			return nil
+		}
+		if d.opts.Namespace != "" && !d.opts.AllNamespaces && c.namespace != d.opts.Namespace {
+			return nil
+		}
+		if d.opts.Container != "" && c.name != d.opts.Container {
+			return nil
+		}
+		if _, ok = found[c.key()]; !ok {
+			found[c.key()] = c
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	containers := make([]*logContainer, 0, len(found))
+	for _, c := range found {
+		containers = append(containers, c)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].key() < containers[j].key()
+	})
+	return containers, nil
+}
+
+// Pods lists a pod for each pod with log files in the directory tree
+func (d *logDir) Pods(context.Context) ([]*Pod, error) {
+	containers, err := d.scan()
+	if err != nil {
+		return nil, mkError(map[string]interface{}{
+			"code":  "log_dir_error",
+			"dir":   d.opts.LogDir,
+			"error": err.Error(),
+		})
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.containers = map[string][]*logContainer{}
+	var pods []*Pod
+	for _, c := range containers {
+		p := &Pod{Name: c.pod, Namespace: c.namespace}
+		if _, ok := d.containers[p.key()]; !ok {
+			pods = append(pods, p)
+		}
+		d.containers[p.key()] = append(d.containers[p.key()], c)
+	}
+	return pods, nil
+}
+
+// cutoff returns the time lines must have been written at or after to be sent, from sinceTime or else the since and
+// since-time options
+func (d *logDir) cutoff(sinceTime string) (time.Time, error) {
+	switch {
+	case sinceTime != "":
+		return time.Parse(time.RFC3339, sinceTime)
+	case d.opts.SinceTime != "":
+		return time.Parse(time.RFC3339, d.opts.SinceTime)
+	case d.opts.Since != "":
+		since, err := time.ParseDuration(d.opts.Since)
+		return time.Now().Add(-since), err
+	}
+	return time.Time{}, nil
+}
+
+// Logs streams the lines of the log files of each container of a pod, joining partial lines. While following, files
+// are followed as they grow, are rotated, and as containers restart
+func (d *logDir) Logs(ctx context.Context, errChan chan<- error, p *Pod, sinceTime string) (<-chan string, error) {
+	cutoff, err := d.cutoff(sinceTime)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	containers := d.containers[p.key()]
+	d.mu.Unlock()
+	ch := make(chan string)
+	go func() {
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			first error
+		)
+		for _, c := range containers {
+			wg.Add(1)
+			go func(c *logContainer) {
+				defer wg.Done()
+				if err := d.tail(ctx, c, cutoff, ch); err != nil {
+					mu.Lock()
+					if first == nil {
+						first = err
+					}
+					mu.Unlock()
+				}
+			}(c)
+		}
+		wg.Wait()
+		errChan <- first
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// files returns the log files of a container in the order they were written, oldest first
+func (d *logDir) files(c *logContainer) ([]string, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		path     string
+		modified time.Time
+	}
+	var files []file
+	for _, entry := range entries {
+		path := filepath.Join(c.dir, entry.Name())
+		if other, ok := identify(path); !ok || other.key() != c.key() {
+			continue
+		}
+		// files are usually links, so stat follows them
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			files = append(files, file{path, info.ModTime()})
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].modified.Equal(files[j].modified) {
+			return files[i].path < files[j].path
+		}
+		return files[i].modified.Before(files[j].modified)
+	})
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+// tail sends the lines of the log files of a container, then while following, waits for lines to be appended to the
+// newest file, reopening it when it is rotated or truncated and moving on to a newer file when the container restarts
+func (d *logDir) tail(ctx context.Context, c *logContainer, cutoff time.Time, ch chan<- string) error {
+	files, err := d.files(c)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	dec := &decoder{container: c, cutoff: cutoff, partial: map[string]partialLine{}}
+	send := func(raw string) bool {
+		line, ok := dec.decode(raw)
+		if !ok {
+			return true
+		}
+		select {
+		case ch <- line:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for len(files) > 0 {
+		path := files[0]
+		files = files[1:]
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		var (
+			rd      = bufio.NewReader(f)
+			offset  int64
+			pending string
+		)
+		// read sends the complete lines of the file up to its end, holding back a final line that is still being
+		// written unless the file is done with
+		read := func(done bool) (bool, error) {
+			for {
+				line, err := rd.ReadString('\n')
+				offset += int64(len(line))
+				pending += line
+				if err == io.EOF {
+					if done && pending != "" {
+						ok := send(pending)
+						pending = ""
+						return ok, nil
+					}
+					return true, nil
+				}
+				if err != nil {
+					return false, err
+				}
+				if !send(pending) {
+					return false, nil
+				}
+				pending = ""
+			}
+		}
+		following := d.opts.Follow && len(files) == 0
+		for following {
+			if ok, err := read(false); !ok || err != nil {
+				f.Close()
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				f.Close()
+				return nil
+			case <-time.After(FilePollInterval):
+			}
+			current, err := os.Stat(path)
+			switch {
+			case err != nil:
+				// the file is being rotated or the pod was removed
+			case !os.SameFile(info, current):
+				// the file was rotated, so a new file takes its place once the rest of the old one is read
+				files, following = []string{path}, false
+			case current.Size() < offset:
+				if _, err = f.Seek(0, io.SeekStart); err != nil {
+					f.Close()
+					return err
+				}
+				rd.Reset(f)
+				offset, pending = 0, ""
+			default:
+				// the container restarted if it writes to a newer file
+				if newer, err := d.files(c); err == nil && len(newer) > 0 && newer[len(newer)-1] != path {
+					files, following = newer[len(newer)-1:], false
+				}
+			}
+		}
+		ok, err := read(true)
+		f.Close()
+		if !ok || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partialLine is the start of a line written in several parts, along with the time the first part was written
+type partialLine struct {
+	time, msg string
+}
+
+// decoder converts the lines of a container's CRI and Docker json-file log files into lines formatted like those of
+// other backends, joining partial lines
+type decoder struct {
+	container *logContainer
+	cutoff    time.Time
+	// partial holds the parts of the line being written to each output stream
+	partial map[string]partialLine
+}
+
+// decode returns the line to send for a line of a log file, or false if it is part of a line that is continued by the
+// next one or was written before the cutoff. CRI lines are formatted as "<time> <stream> <P|F> <message>", where P
+// marks partial lines, and Docker lines as {"log":"<message>\n","stream":"<stream>","time":"<time>"}, where messages
+// without a trailing newline are partial. Other lines are sent as they are
+func (dec *decoder) decode(raw string) (string, bool) {
+	raw = strings.TrimRight(raw, "\r\n")
+	var (
+		timestamp, stream string
+		msg               = raw
+		partial           bool
+	)
+	if strings.HasPrefix(raw, "{") {
+		var docker struct{ Log, Stream, Time string }
+		if err := json.Unmarshal([]byte(raw), &docker); err == nil && docker.Time != "" {
+			timestamp, stream = docker.Time, docker.Stream
+			msg = strings.TrimSuffix(docker.Log, "\n")
+			partial = !strings.HasSuffix(docker.Log, "\n")
+		}
+	} else if f := strings.SplitN(raw, " ", 4); len(f) >= 3 && (strings.HasPrefix(f[2], "P") || strings.HasPrefix(f[2], "F")) {
+		if _, err := time.Parse(time.RFC3339Nano, f[0]); err == nil {
+			timestamp, stream, msg, partial = f[0], f[1], "", f[2][0] == 'P'
+			if len(f) == 4 {
+				msg = f[3]
+			}
+		}
+	}
+	if p, ok := dec.partial[stream]; ok {
+		timestamp, msg = p.time, p.msg+msg
+	}
+	if partial {
+		dec.partial[stream] = partialLine{timestamp, msg}
+		return "", false
+	}
+	delete(dec.partial, stream)
+	if !dec.cutoff.IsZero() {
+		if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil && t.Before(dec.cutoff) {
+			return "", false
+		}
+	}
+	prefix := "pod/" + dec.container.pod + "/" + dec.container.name
+	if stream != "" {
+		prefix += "/" + stream
+	}
+	return "[" + prefix + "] " + timestamp + " " + msg, true
+}
@@ -0,0 +1,82 @@
+package logs
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LogfmtPair is a key=value pair of a logfmt line
+type LogfmtPair struct {
+	Key   string
+	Value string
+	// Start is the byte offset of the key in the line and End the offset just after the value, including any quotes
+	Start, End int
+}
+
+// ParseLogfmt splits a logfmt line into its key=value pairs. Values containing spaces are double quoted with Go style
+// escapes. It returns false if the line isn't logfmt, which requires at least two pairs and nothing but pairs
+func ParseLogfmt(line string) ([]LogfmtPair, bool) {
+	var pairs []LogfmtPair
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		p := LogfmtPair{Start: i}
+		for i < len(line) && !strings.ContainsRune(" \t=\"", rune(line[i])) {
+			i++
+		}
+		if i == p.Start || i == len(line) || line[i] != '=' {
+			return nil, false
+		}
+		p.Key = line[p.Start:i]
+		i++
+		if i < len(line) && line[i] == '"' {
+			start := i
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(line) {
+				return nil, false
+			}
+			i++
+			v, err := strconv.Unquote(line[start:i])
+			if err != nil {
+				v = line[start+1 : i-1]
+			}
+			p.Value = v
+		} else {
+			start := i
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				i++
+			}
+			p.Value = line[start:i]
+		}
+		p.End = i
+		pairs = append(pairs, p)
+	}
+	return pairs, len(pairs) >= 2
+}
+
+// parseLogfmt returns the fields of a logfmt line, or nil if s is not one
+func parseLogfmt(s string) map[string]interface{} {
+	pairs, ok := ParseLogfmt(s)
+	if !ok {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(pairs))
+	for _, p := range pairs {
+		fields[p.Key] = p.Value
+	}
+	return fields
+}
+
+// QuoteLogfmt formats a value for a logfmt line, quoting it if it is empty or contains spaces, quotes or equals signs
+func QuoteLogfmt(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\r\n\"=\\") {
+		return strconv.Quote(v)
+	}
+	return v
+}
@@ -1,38 +1,25 @@
 package logs
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
 	"sync"
-
-	"github.com/alecthomas/chroma/quick"
-	"github.com/fatih/color"
+	"time"
 
 	"github.com/ryantate13/klogs/args"
-	"github.com/ryantate13/klogs/exec"
 	"github.com/ryantate13/klogs/fn"
 )
 
-type colorFunc func(format string, a ...interface{}) string
-
 var (
-	colors = []colorFunc{
-		color.CyanString,
-		color.YellowString,
-		color.GreenString,
-		color.MagentaString,
-		color.BlueString,
-		color.HiCyanString,
-		color.HiYellowString,
-		color.HiGreenString,
-		color.HiMagentaString,
-		color.HiBlueString,
-	}
-	noColor colorFunc = fmt.Sprintf
+	// PollInterval is how often the pod list is refreshed while following logs
+	PollInterval = 2 * time.Second
+	// ReconnectBackoff is how long to wait before reconnecting a dropped stream. It doubles after each failed attempt
+	ReconnectBackoff = time.Second
+	// MaxReconnectBackoff caps the time between attempts to reconnect a dropped stream
+	MaxReconnectBackoff = 30 * time.Second
 )
 
 func mkError(err map[string]interface{}) error {
@@ -40,150 +27,540 @@ func mkError(err map[string]interface{}) error {
 	return errors.New(string(j))
 }
 
-type pod struct {
-	Name, Namespace string
+// Pod identifies a pod whose logs can be streamed
+type Pod struct {
+	Name, Namespace, Phase string
+	// Context is the kubeconfig context of the cluster the pod is in, when reading from several clusters
+	Context string
+}
+
+func (p *Pod) key() string {
+	if p.Context != "" {
+		return p.Context + "/" + p.Namespace + "/" + p.Name
+	}
+	return p.Namespace + "/" + p.Name
+}
+
+// streamable reports whether logs can be streamed for the pod yet
+func (p *Pod) streamable() bool {
+	return p.Phase != "Pending"
+}
+
+// Backend lists pods and streams their logs from a cluster
+type Backend interface {
+	// Pods lists the pods matching the namespace and label options
+	Pods(ctx context.Context) ([]*Pod, error)
+	// Logs streams the logs of a pod to a channel, with each line formatted as "[pod/<name>/<container>] <timestamp>
+	// <log entry>" like the output of kubectl logs --prefix --timestamps, or "[pod/<name>/<container>/<stream>] ..."
+	// for backends that know which output stream each line was written to. If sinceTime is set, it overrides the since,
+	// since-time and tail options
+	Logs(ctx context.Context, errChan chan<- error, p *Pod, sinceTime string) (<-chan string, error)
+}
+
+// Observer is notified of each entry that passes the filters from the goroutine of the stream that read it, before the
+// entry is sent, so observers must be safe for concurrent use
+type Observer interface {
+	Observe(e *Entry)
+}
+
+// Watcher is implemented by backends that are notified of changes to pods rather than having to poll for them
+type Watcher interface {
+	// Watch sends the full list of pods matching the namespace and label options each time it changes
+	Watch(ctx context.Context) (<-chan []*Pod, error)
+}
+
+func matches(opts *args.Args, name string) bool {
+	// filter by label only
+	if len(opts.Query) == 0 {
+		return true
+	}
+	// all search terms must match
+	if opts.All {
+		return fn.Reduce(opts.Query, func(a bool, c string) bool {
+			return a && strings.Index(name, c) != -1
+		}, true)
+	}
+	// default behavior - one or more search terms must match
+	return fn.Reduce(opts.Query, func(a bool, c string) bool {
+		return a || strings.Index(name, c) != -1
+	}, false)
+}
+
+// position records the timestamp of the last line received from a container and how many lines shared it, so that
+// lines replayed by a reconnected stream can be skipped
+type position struct {
+	time     time.Time
+	seen     int
+	replayed int
+	resuming bool
 }
 
-func Read(ctx context.Context, opts *args.Args, ex exec.Executor, tty string) (<-chan string, <-chan error, error) {
-	logChan := make(chan string)
-	kubectl := []string{"kubectl"}
-	for k, v := range map[string]string{"--kubeconfig": opts.KubeConfig, "--context": opts.Context} {
-		if v != "" {
-			kubectl = append(kubectl, k, v)
+type podStream struct {
+	pod    *Pod
+	phase  string
+	cancel context.CancelFunc
+	last   map[string]*position
+	// schemas caches the schema recognized for each container
+	schemas map[string]*schema
+	// groups holds the multi-line entry pending for each container stream
+	groups map[string]*group
+	// runs holds the run of repeated entries pending for each container stream
+	runs map[string]*run
+}
+
+// accept reports whether a line from the given container should be sent, skipping lines that were already sent before
+// the stream reconnected
+func (ps *podStream) accept(container string, ts time.Time) bool {
+	pos, ok := ps.last[container]
+	if !ok {
+		ps.last[container] = &position{time: ts, seen: 1}
+		return true
+	}
+	if pos.resuming {
+		if ts.Before(pos.time) {
+			return false
+		}
+		if ts.Equal(pos.time) && pos.replayed < pos.seen {
+			pos.replayed++
+			return false
+		}
+		pos.resuming = false
+	}
+	if ts.Equal(pos.time) {
+		pos.seen++
+	} else {
+		pos.time, pos.seen = ts, 1
+	}
+	return true
+}
+
+// since returns the time a reconnected stream should resume from, which is the earliest of the last timestamps seen
+// across all containers
+func (ps *podStream) since() string {
+	var since time.Time
+	for _, pos := range ps.last {
+		pos.resuming, pos.replayed = true, 0
+		if since.IsZero() || pos.time.Before(since) {
+			since = pos.time
 		}
 	}
-	getPods := append(kubectl, "get", "pods", "-o", "custom-columns=:metadata.name,:metadata.namespace")
-	if opts.AllNamespaces {
-		getPods = append(getPods, "--all-namespaces")
-	} else if opts.Namespace != "" {
-		getPods = append(getPods, "--namespace", opts.Namespace)
+	if since.IsZero() {
+		return ""
 	}
-	for _, l := range opts.Label {
-		getPods = append(getPods, "-l", l)
+	return since.UTC().Format(time.RFC3339)
+}
+
+type reader struct {
+	ctx        context.Context
+	opts       *args.Args
+	backend    Backend
+	logChan    chan *Entry
+	errChan    chan error
+	wg         *sync.WaitGroup
+	mu         sync.Mutex
+	streams    map[string]*podStream
+	merger     *merger
+	filter     *filter
+	normalizer *normalizer
+	grouper    *grouper
+	collapser  *collapser
+	observers  []Observer
+}
+
+// Read streams the logs of all pods matching opts. In follow mode pods are watched so that streams are started for new
+// pods and stopped for deleted ones, and dropped streams are reconnected. If opts.Merge is set, entries from all
+// streams are merged in timestamp order. Each observer is notified of the entries sent by each stream
+func Read(ctx context.Context, opts *args.Args, backend Backend, observers ...Observer) (<-chan *Entry, <-chan error, error) {
+	f, err := newFilter(opts)
+	if err != nil {
+		return nil, nil, err
 	}
-	nsPods, err := ex.Sync(ctx, getPods...)
+	n, err := newNormalizer(opts)
 	if err != nil {
-		return nil, nil, mkError(map[string]interface{}{
-			"code":    "get_pods_error",
-			"command": getPods,
-			"error":   err.Error(),
-		})
+		return nil, nil, err
 	}
-	pods := fn.Filter(
-		fn.Map(nsPods, func(s string) *pod {
-			f := strings.Fields(s)
-			return &pod{f[0], f[1]}
-		}), func(p *pod) bool {
-			// filter by label only
-			if len(opts.Query) == 0 {
-				return true
-			}
-			// all search terms must match
-			if opts.All {
-				return fn.Reduce(opts.Query, func(a bool, c string) bool {
-					return a && strings.Index(p.Name, c) != -1
-				}, true)
-			}
-			// default behavior - one or more search terms must match
-			return fn.Reduce(opts.Query, func(a bool, c string) bool {
-				return a || strings.Index(p.Name, c) != -1
-			}, false)
-		})
-	if len(pods) == 0 {
+	g, err := newGrouper(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := newCollapser(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	pods, err := backend.Pods(ctx)
+	// errors listing the pods of some clusters are reported as notices, along with the logs of the others
+	var warnings []string
+	if clusterErrs := (ClusterErrors{}); errors.As(err, &clusterErrs) {
+		warnings, err = clusterErrs.messages(), nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	pods = fn.Filter(pods, func(p *Pod) bool {
+		return matches(opts, p.Name)
+	})
+	if len(pods) == 0 && !opts.Follow {
 		return nil, nil, mkError(map[string]interface{}{
 			"code":  "no_pods_found",
 			"error": "no available pods match query terms",
 			"opts":  opts,
 		})
 	}
-	wg := &sync.WaitGroup{}
-	wg.Add(len(pods))
-	errChan := make(chan error)
-	logCmd := append(kubectl, "logs")
-	for k, v := range map[string]bool{
-		"--all-containers": opts.AllContainers,
-		"--follow":         opts.Follow,
-		"--prefix":         opts.Prefix,
-		"--previous":       opts.Previous,
-		"--timestamps":     opts.Timestamps,
-	} {
-		if v {
-			logCmd = append(logCmd, k)
-		}
-	}
-	for k, v := range map[string]string{
-		"--container":   opts.Container,
-		"--limit-bytes": opts.LimitBytes,
-		"--since":       opts.Since,
-		"--since-time":  opts.SinceTime,
-		"--tail":        opts.Tail,
-	} {
-		if v != "" {
-			kubectl = append(kubectl, k, v)
-		}
-	}
-	for i, p := range pods {
-		podLogCmd := append(logCmd, "-n", p.Namespace, p.Name)
-		colorize := noColor
-		if tty != "" {
-			colorize = colors[i%len(colors)]
-		}
-		c, err := ex.Stream(ctx, errChan, podLogCmd...)
-		if err != nil {
-			return nil, nil, mkError(map[string]interface{}{
-				"code":    "logs_error",
-				"command": podLogCmd,
-				"error":   err.Error(),
-				"pod":     p,
-			})
-		}
-		prettyJSON := opts.JSON && tty != ""
-		numParts := 1
-		for _, b := range []bool{opts.Prefix, opts.Timestamps} {
-			if b {
-				numParts++
-			}
-		}
-		go func(ch <-chan string) {
+	r := &reader{
+		ctx:        ctx,
+		opts:       opts,
+		backend:    backend,
+		logChan:    make(chan *Entry),
+		errChan:    make(chan error),
+		wg:         &sync.WaitGroup{},
+		streams:    map[string]*podStream{},
+		filter:     f,
+		normalizer: n,
+		grouper:    g,
+		collapser:  c,
+		observers:  observers,
+	}
+	if opts.Merge {
+		r.merger = newMerger(r.logChan, opts.Follow)
+	}
+	for _, p := range pods {
+		if opts.Follow && !p.streamable() {
+			continue
+		}
+		if err = r.stream(p); err != nil {
+			if p.Context == "" {
+				return nil, nil, err
+			}
+			warnings = append(warnings, fmt.Sprintf("unable to stream pod %s: %s", p.key(), err.Error()))
+		}
+	}
+	if len(warnings) > 0 {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for _, w := range warnings {
+				r.notice("%s", w)
+			}
+		}()
+	}
+	if opts.Follow {
+		r.wg.Add(1)
+		go r.watch(pods)
+	}
+	go func() {
+		r.wg.Wait()
+		if r.merger != nil {
+			r.merger.close()
+			<-r.merger.finished
+		}
+		close(r.logChan)
+	}()
+	return r.logChan, r.errChan, nil
+}
+
+func (r *reader) notice(format string, a ...interface{}) {
+	r.logChan <- &Entry{Line: fmt.Sprintf(format, a...), Time: time.Now(), Notice: true}
+}
+
+// watch follows changes to the pods matching the query until the context is cancelled, starting and stopping streams
+// as pods come and go
+func (r *reader) watch(pods []*Pod) {
+	defer r.wg.Done()
+	if len(pods) == 0 {
+		r.notice("waiting for pods matching query")
+	}
+	for pods := range r.updates() {
+		current := map[string]bool{}
+		for _, p := range pods {
+			if !matches(r.opts, p.Name) {
+				continue
+			}
+			current[p.key()] = true
+			if ps, ok := r.streams[p.key()]; ok {
+				r.mu.Lock()
+				ps.phase = p.Phase
+				r.mu.Unlock()
+				continue
+			}
+			if !p.streamable() {
+				continue
+			}
+			r.notice("pod %s added", p.key())
+			if err := r.stream(p); err != nil {
+				if p.Context != "" {
+					r.notice("unable to stream pod %s: %s", p.key(), err.Error())
+					continue
+				}
+				r.errChan <- err
+				return
+			}
+		}
+		for key, ps := range r.streams {
+			if !current[key] {
+				ps.cancel()
+				delete(r.streams, key)
+				r.notice("pod %s deleted", key)
+			}
+		}
+	}
+}
+
+// updates returns a channel of pod lists that is closed once the context is cancelled. Backends that implement
+// Watcher are watched, otherwise pods are listed every PollInterval
+func (r *reader) updates() <-chan []*Pod {
+	if w, ok := r.backend.(Watcher); ok {
+		ch, err := w.Watch(r.ctx)
+		if err == nil {
+			return ch
+		}
+		r.notice("unable to watch pods, polling instead: %s", err.Error())
+	}
+	ch := make(chan []*Pod)
+	go func() {
+		defer close(ch)
+		t := time.NewTicker(PollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-t.C:
+			}
+			pods, err := r.backend.Pods(r.ctx)
+			if clusterErrs := (ClusterErrors{}); errors.As(err, &clusterErrs) {
+				for _, msg := range clusterErrs.messages() {
+					r.notice("%s", msg)
+				}
+			} else if err != nil {
+				if r.ctx.Err() == nil {
+					r.notice("unable to refresh pods: %s", err.Error())
+				}
+				continue
+			}
+			select {
+			case ch <- pods:
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// running reports whether the pod is still running. Pods are listed again, since a stream usually ends because the
+// pod's containers exited, which the phase from the last time pods were listed doesn't reflect yet. If listing fails,
+// the last phase is used
+func (r *reader) running(ps *podStream) bool {
+	pods, err := r.backend.Pods(r.ctx)
+	if clusterErrs := (ClusterErrors{}); errors.As(err, &clusterErrs) {
+		for _, msg := range clusterErrs.messages() {
+			r.notice("%s", msg)
+		}
+		err = nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		listed := false
+		for _, p := range pods {
+			if p.key() == ps.pod.key() {
+				ps.phase, listed = p.Phase, true
+			}
+		}
+		if !listed {
+			return false
+		}
+	}
+	return ps.phase == "" || ps.phase == "Running"
+}
+
+// stream starts streaming logs for a single pod. The stream ends when the pod's logs are exhausted or when it is
+// cancelled, either by the parent context or by the pod being deleted. In follow mode, a stream that ends while its
+// pod is still running is reconnected with exponential backoff, resuming from the last timestamp received
+func (r *reader) stream(p *Pod) error {
+	ctx, cancel := context.WithCancel(r.ctx)
+	ps := &podStream{pod: p, phase: p.Phase, cancel: cancel, last: map[string]*position{}}
+	ps.schemas, ps.groups, ps.runs = map[string]*schema{}, map[string]*group{}, map[string]*run{}
+	c, streamErrs, err := r.start(ctx, ps, "")
+	if err != nil {
+		cancel()
+		return err
+	}
+	r.streams[p.key()] = ps
+	if r.merger != nil {
+		r.merger.add(ps)
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer cancel()
+		if r.merger != nil {
+			defer r.merger.done(ps)
+		}
+		backoff := ReconnectBackoff
+		for {
+			connected := time.Now()
+			received, err := r.forward(ctx, ps, c, streamErrs)
+			if ctx.Err() != nil {
+				return
+			}
+			if !r.opts.Follow {
+				if err != nil && p.Context != "" {
+					r.notice("stream of pod %s failed: %s", p.key(), strings.TrimSpace(err.Error()))
+				} else if err != nil {
+					r.errChan <- err
+				}
+				return
+			}
+			if !r.running(ps) {
+				r.notice("pod %s is no longer running", p.key())
+				return
+			}
+			if received > 0 || time.Since(connected) > MaxReconnectBackoff {
+				backoff = ReconnectBackoff
+			}
+			reason := "stream ended"
+			if err != nil {
+				reason = strings.TrimSpace(err.Error())
+			}
+			r.notice("lost connection to pod %s (%s), reconnecting in %s", p.key(), reason, backoff)
 			for {
 				select {
-				case line, ok := <-ch:
-					if !ok {
-						wg.Done()
-						return
-					}
-					parts := strings.SplitN(line, " ", numParts)
-					prefix := ""
-					if opts.Prefix {
-						prefix = colorize(parts[0]) + " "
-					}
-					timestamp := ""
-					if opts.Timestamps {
-						i := 0
-						if !opts.Prefix {
-							i = 1
-						}
-						timestamp = parts[i] + " "
-					}
-					logEntry := parts[len(parts)-1]
-					if prettyJSON {
-						b := bytes.NewBuffer(nil)
-						err := quick.Highlight(b, logEntry, "json", tty, opts.Theme)
-						if err == nil {
-							logEntry = b.String()
-						}
-					}
-					logChan <- prefix + timestamp + logEntry
 				case <-ctx.Done():
-					wg.Done()
 					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if backoff > MaxReconnectBackoff {
+					backoff = MaxReconnectBackoff
+				}
+				if c, streamErrs, err = r.start(ctx, ps, ps.since()); err == nil {
+					break
 				}
+				r.notice("unable to reconnect to pod %s, retrying in %s", p.key(), backoff)
 			}
-		}(c)
-	}
-	go func() {
-		wg.Wait()
-		close(logChan)
+			r.notice("reconnected to pod %s", p.key())
+		}
 	}()
-	return logChan, errChan, nil
+	return nil
+}
+
+func (r *reader) start(ctx context.Context, ps *podStream, sinceTime string) (<-chan string, <-chan error, error) {
+	streamErrs := make(chan error)
+	c, err := r.backend.Logs(ctx, streamErrs, ps.pod, sinceTime)
+	if err != nil {
+		return nil, nil, mkError(map[string]interface{}{
+			"code":  "logs_error",
+			"error": err.Error(),
+			"pod":   ps.pod,
+		})
+	}
+	return c, streamErrs, nil
+}
+
+// forward sends lines from a stream to the log channel until the stream ends, returning the number of lines received
+// and the error the stream ended with, if any. When grouping multi-line entries, each line is held back until the next
+// line from its container shows whether it continues the entry, and while following, until GroupTimeout has passed.
+// Likewise, when collapsing repeated lines, each line is held back until a different line or the quiet interval ends
+// its run
+func (r *reader) forward(ctx context.Context, ps *podStream, c <-chan string, streamErrs <-chan error) (int, error) {
+	var (
+		received int
+		err      error
+		expiry   <-chan time.Time
+	)
+	for {
+		select {
+		case e := <-streamErrs:
+			if e != nil {
+				err = e
+			}
+		case <-expiry:
+			expiry = nil
+			if next := r.expire(ps, time.Now()); next > 0 {
+				expiry = time.After(next)
+			}
+		case line, ok := <-c:
+			if !ok {
+				if ctx.Err() == nil {
+					r.expire(ps, time.Time{})
+				}
+				return received, err
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+			e := parse(ps.pod, line)
+			if r.opts.Follow && !e.Time.IsZero() && !ps.accept(e.Container, e.Time) {
+				continue
+			}
+			received++
+			if r.opts.Unwrap {
+				unwrap(e)
+			}
+			if r.grouper == nil {
+				r.send(ps, e)
+			} else if done := ps.add(r.grouper, e); done != nil {
+				r.send(ps, done)
+			}
+			if r.opts.Follow && expiry == nil && (len(ps.groups) > 0 || len(ps.runs) > 0) {
+				expiry = time.After(r.timeout())
+			}
+		}
+	}
+}
+
+// timeout returns the shortest time that entries are held back for
+func (r *reader) timeout() time.Duration {
+	if r.grouper != nil && (r.collapser == nil || GroupTimeout < r.collapser.quiet) {
+		return GroupTimeout
+	}
+	return r.collapser.quiet
+}
+
+// expire sends the pending multi-line entries and runs of repeated entries that have been held back long enough as of
+// now, or all of them if now is zero, returning how long until the next one expires
+func (r *reader) expire(ps *podStream, now time.Time) time.Duration {
+	expired, next := ps.expireGroups(now)
+	for _, e := range expired {
+		r.send(ps, e)
+	}
+	if r.collapser == nil {
+		return next
+	}
+	expired, nextRun := ps.expireRuns(r.collapser, now)
+	for _, e := range expired {
+		r.output(ps, e)
+	}
+	if next == 0 || (nextRun > 0 && nextRun < next) {
+		next = nextRun
+	}
+	return next
+}
+
+// send normalizes and filters an entry and notifies the observers of it, then outputs it, or collapses it into the
+// pending run of repeats of its container stream
+func (r *reader) send(ps *podStream, e *Entry) {
+	ps.schemas[e.Container] = r.normalizer.apply(ps.schemas[e.Container], e)
+	if !r.filter.match(e) {
+		return
+	}
+	for _, o := range r.observers {
+		o.Observe(e)
+	}
+	if r.collapser != nil {
+		if e = ps.collapse(r.collapser, e); e == nil {
+			return
+		}
+	}
+	r.output(ps, e)
+}
+
+// output sends an entry to the log channel or the merger
+func (r *reader) output(ps *podStream, e *Entry) {
+	if r.merger != nil {
+		r.merger.push(ps, e)
+	} else {
+		r.logChan <- e
+	}
 }
@@ -0,0 +1,1129 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/internal/mocks"
+)
+
+// fakeStream returns a Stream stub that sends the given lines for each pod, then waits for the stream to be cancelled
+// if follow is true
+func fakeStream(lines map[string][]string, follow bool) func(context.Context, chan<- error, ...string) (<-chan string, error) {
+	return func(ctx context.Context, _ chan<- error, cmdAndArgs ...string) (<-chan string, error) {
+		ch := make(chan string)
+		go func() {
+			defer close(ch)
+			for _, l := range lines[cmdAndArgs[len(cmdAndArgs)-1]] {
+				select {
+				case ch <- l:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if follow {
+				<-ctx.Done()
+			}
+		}()
+		return ch, nil
+	}
+}
+
+// collect returns the lines of the next n entries, with notices marked by a "[klogs]" prefix
+func collect(t *testing.T, logChan <-chan *Entry, errChan <-chan error, n int) []string {
+	var got []string
+	for len(got) < n {
+		select {
+		case err := <-errChan:
+			require.NoError(t, err)
+		case e, ok := <-logChan:
+			if !ok {
+				return got
+			}
+			if e.Notice {
+				got = append(got, "[klogs] "+e.Line)
+			} else {
+				got = append(got, e.Line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for log lines, got %v", got)
+		}
+	}
+	return got
+}
+
+func TestRead(t *testing.T) {
+	t.Run("returns an error if listing pods fails", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns(nil, context.DeadlineExceeded)
+		opts := &args.Args{Query: []string{"foo"}}
+		_, _, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "get_pods_error")
+	})
+	t.Run("returns an error if no pods match the query", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"bar default Running"}, nil)
+		opts := &args.Args{Query: []string{"foo"}}
+		_, _, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "no_pods_found")
+	})
+	t.Run("streams the logs of all matching pods", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo-1 default Running", "foo-2 ns Running", "bar default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{
+			"foo-1": {"[pod/foo-1/app] 2022-11-09T12:00:00Z one", "[pod/foo-1/app] 2022-11-09T12:00:01Z two"},
+			"foo-2": {"[pod/foo-2/app] 2022-11-09T12:00:00Z three"},
+			"bar":   {"[pod/bar/app] 2022-11-09T12:00:00Z four"},
+		}, false))
+		opts := &args.Args{Query: []string{"foo"}, Tail: "10", Namespace: "ns"}
+		logChan, errChan, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"one", "two", "three"}, collect(t, logChan, errChan, 4))
+		require.Equal(t, 2, ex.StreamCallCount())
+		_, getPods := ex.SyncArgsForCall(0)
+		require.Equal(t, []string{
+			"kubectl", "get", "pods", "-o", "custom-columns=:metadata.name,:metadata.namespace,:status.phase",
+			"--namespace", "ns",
+		}, getPods)
+		_, _, logs := ex.StreamArgsForCall(0)
+		require.Subset(t, logs, []string{"kubectl", "logs", "--prefix", "--timestamps", "--tail", "10", "-n"})
+	})
+	t.Run("runs the configured kubectl command with global flags", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {"[pod/foo/app] 2022-11-09T12:00:00Z one"}}, false))
+		opts := &args.Args{
+			Query:         []string{"foo"},
+			KubectlPrefix: "microk8s",
+			KubectlFlags:  []string{"--as=admin", "--as-group system:masters", "--insecure-skip-tls-verify"},
+		}
+		logChan, errChan, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{"one"}, collect(t, logChan, errChan, 2))
+		global := []string{"microk8s", "kubectl", "--as=admin", "--as-group", "system:masters", "--insecure-skip-tls-verify"}
+		_, getPods := ex.SyncArgsForCall(0)
+		require.Equal(t, global, getPods[:len(global)])
+		require.Equal(t, []string{"get", "pods"}, getPods[len(global):len(global)+2])
+		_, _, logs := ex.StreamArgsForCall(0)
+		require.Equal(t, global, logs[:len(global)])
+		require.Equal(t, []string{"logs", "--prefix"}, logs[len(global):len(global)+2])
+	})
+	t.Run("runs kubectl binaries whose path contains spaces", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		opts := &args.Args{Kubectl: "/opt/My Tools/oc"}
+		_, err := Kubectl(opts, ex).Pods(context.Background())
+		require.NoError(t, err)
+		_, getPods := ex.SyncArgsForCall(0)
+		require.Equal(t, []string{"/opt/My Tools/oc", "get", "pods"}, getPods[:3])
+	})
+	t.Run("watches for pods being added and deleted while following", func(t *testing.T) {
+		defer func(d time.Duration) { PollInterval = d }(PollInterval)
+		PollInterval = 10 * time.Millisecond
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturnsOnCall(0, []string{"foo-1 default Running", "foo-2 default Pending"}, nil)
+		ex.SyncReturns([]string{"foo-2 default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{
+			"foo-1": {"[pod/foo-1/app] 2022-11-09T12:00:00.000000001Z one"},
+			"foo-2": {"[pod/foo-2/app] 2022-11-09T12:00:00.000000002Z two"},
+		}, true))
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := &args.Args{Query: []string{"foo"}, Follow: true}
+		logChan, errChan, err := Read(ctx, opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{
+			"one",
+			"[klogs] pod default/foo-2 added",
+			"two",
+			"[klogs] pod default/foo-1 deleted",
+		}, collect(t, logChan, errChan, 4))
+		require.Equal(t, 2, ex.StreamCallCount())
+		cancel()
+		for range logChan {
+		}
+	})
+	t.Run("reconnects dropped streams while following without repeating lines", func(t *testing.T) {
+		defer func(d time.Duration) { ReconnectBackoff = d }(ReconnectBackoff)
+		ReconnectBackoff = 10 * time.Millisecond
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		first := fakeStream(map[string][]string{"foo": {
+			"[pod/foo/app] 2022-11-09T12:00:00.1Z one",
+			"[pod/foo/app] 2022-11-09T12:00:01.1Z two",
+			"[pod/foo/app] 2022-11-09T12:00:01.1Z three",
+		}}, false)
+		reconnected := fakeStream(map[string][]string{"foo": {
+			"[pod/foo/app] 2022-11-09T12:00:00.1Z one",
+			"[pod/foo/app] 2022-11-09T12:00:01.1Z two",
+			"[pod/foo/app] 2022-11-09T12:00:01.1Z three",
+			"[pod/foo/app] 2022-11-09T12:00:01.1Z four",
+			"[pod/foo/app] 2022-11-09T12:00:02.1Z five",
+		}}, true)
+		ex.StreamCalls(func(ctx context.Context, errChan chan<- error, cmdAndArgs ...string) (<-chan string, error) {
+			if ex.StreamCallCount() == 1 {
+				return first(ctx, errChan, cmdAndArgs...)
+			}
+			return reconnected(ctx, errChan, cmdAndArgs...)
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := &args.Args{Query: []string{"foo"}, Follow: true, Tail: "3"}
+		logChan, errChan, err := Read(ctx, opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"one",
+			"two",
+			"three",
+			"[klogs] lost connection to pod default/foo (stream ended), reconnecting in 10ms",
+			"[klogs] reconnected to pod default/foo",
+			"four",
+			"five",
+		}, collect(t, logChan, errChan, 7))
+		_, _, reconnect := ex.StreamArgsForCall(1)
+		require.Subset(t, reconnect, []string{"--since-time", "2022-11-09T12:00:01Z"})
+		require.NotContains(t, reconnect, "--tail")
+		cancel()
+		for range logChan {
+		}
+	})
+	t.Run("does not reconnect the streams of pods that completed since they were listed", func(t *testing.T) {
+		defer func(d time.Duration) { ReconnectBackoff = d }(ReconnectBackoff)
+		ReconnectBackoff = 10 * time.Millisecond
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturnsOnCall(0, []string{"foo default Running"}, nil)
+		ex.SyncReturns([]string{"foo default Succeeded"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {"[pod/foo/app] 2022-11-09T12:00:00Z done"}}, false))
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := &args.Args{Query: []string{"foo"}, Follow: true}
+		logChan, errChan, err := Read(ctx, opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{"done", "[klogs] pod default/foo is no longer running"}, collect(t, logChan, errChan, 2))
+		require.Equal(t, 1, ex.StreamCallCount())
+		cancel()
+		for range logChan {
+		}
+	})
+}
+
+func TestMerge(t *testing.T) {
+	t.Run("merges the backlogs of all pods in timestamp order", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo-1 default Running", "foo-2 default Running", "foo-3 default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{
+			"foo-1": {
+				"[pod/foo-1/app] 2022-11-09T12:00:01Z two",
+				"[pod/foo-1/app] 2022-11-09T12:00:04Z five",
+			},
+			"foo-2": {
+				"[pod/foo-2/app] 2022-11-09T12:00:00Z one",
+				"[pod/foo-2/app] 2022-11-09T12:00:03Z four",
+				"[pod/foo-2/app] 2022-11-09T12:00:05Z six",
+			},
+			"foo-3": {
+				"[pod/foo-3/app] 2022-11-09T12:00:02Z three",
+				"[pod/foo-3/sidecar] 2022-11-09T12:00:06Z seven",
+				"[pod/foo-3/app] 2022-11-09T12:00:05.5Z six and a half",
+			},
+		}, false))
+		opts := &args.Args{Query: []string{"foo"}, Merge: true}
+		logChan, errChan, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"one", "two", "three", "four", "five", "six", "six and a half", "seven",
+		}, collect(t, logChan, errChan, 9))
+	})
+	t.Run("releases entries after the merge window while following", func(t *testing.T) {
+		defer func(d time.Duration) { MergeWindow = d }(MergeWindow)
+		MergeWindow = 10 * time.Millisecond
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo-1 default Running", "foo-2 default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{
+			"foo-1": {
+				"[pod/foo-1/app] 2022-11-09T12:00:01Z two",
+				"[pod/foo-1/app] 2022-11-09T12:00:00Z one",
+			},
+		}, true))
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := &args.Args{Query: []string{"foo"}, Merge: true, Follow: true}
+		logChan, errChan, err := Read(ctx, opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{"one", "two"}, collect(t, logChan, errChan, 2))
+		cancel()
+		for range logChan {
+		}
+	})
+}
+
+func TestMultiline(t *testing.T) {
+	t.Run("groups stack traces into a single entry per container", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {
+			"[pod/foo/app] 2022-11-09T12:00:00Z panic: runtime error: index out of range [1] with length 1",
+			"[pod/foo/sidecar] 2022-11-09T12:00:00Z Traceback (most recent call last):",
+			"[pod/foo/app] 2022-11-09T12:00:00Z ",
+			"[pod/foo/sidecar] 2022-11-09T12:00:00Z   File \"main.py\", line 1, in <module>",
+			"[pod/foo/app] 2022-11-09T12:00:00Z goroutine 1 [running]:",
+			"[pod/foo/app] 2022-11-09T12:00:00Z main.main()",
+			"[pod/foo/sidecar] 2022-11-09T12:00:00Z     fail()",
+			"[pod/foo/app] 2022-11-09T12:00:00Z \t/app/main.go:5 +0x1d",
+			"[pod/foo/sidecar] 2022-11-09T12:00:00Z ValueError: bad",
+			"[pod/foo/sidecar] 2022-11-09T12:00:01Z INFO restarting",
+			"[pod/foo/app] 2022-11-09T12:00:01Z Exception in thread \"main\" java.lang.IllegalStateException: boom",
+			"[pod/foo/app] 2022-11-09T12:00:01Z \tat com.example.Main.main(Main.java:5)",
+			"[pod/foo/app] 2022-11-09T12:00:01Z Caused by: java.io.IOException: disk",
+			"[pod/foo/app] 2022-11-09T12:00:01Z \t... 2 more",
+			"[pod/foo/app] 2022-11-09T12:00:02Z done",
+		}}, false))
+		opts := &args.Args{Query: []string{"foo"}, Multiline: true}
+		logChan, _, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		var got []string
+		levels := map[string]Level{}
+		for e := range logChan {
+			got = append(got, e.Line)
+			levels[e.Line] = e.Level
+		}
+		python := "Traceback (most recent call last):\n  File \"main.py\", line 1, in <module>\n    fail()\nValueError: bad"
+		goPanic := "panic: runtime error: index out of range [1] with length 1\n\ngoroutine 1 [running]:\nmain.main()\n" +
+			"\t/app/main.go:5 +0x1d"
+		java := "Exception in thread \"main\" java.lang.IllegalStateException: boom\n" +
+			"\tat com.example.Main.main(Main.java:5)\nCaused by: java.io.IOException: disk\n\t... 2 more"
+		require.ElementsMatch(t, []string{python, goPanic, "INFO restarting", java, "done"}, got)
+		require.Equal(t, LevelError, levels[python])
+		require.Equal(t, LevelFatal, levels[goPanic])
+		require.Equal(t, LevelError, levels[java])
+		require.Equal(t, LevelInfo, levels["INFO restarting"])
+	})
+	t.Run("starts entries at lines matching custom patterns and filters them as one", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {
+			"[pod/foo/app] 2022-11-09T12:00:00Z 2022-11-09 ERROR request failed",
+			"[pod/foo/app] 2022-11-09T12:00:00Z java.lang.IllegalStateException: boom",
+			"[pod/foo/app] 2022-11-09T12:00:00Z \tat com.example.Handler.handle(Handler.java:42)",
+			"[pod/foo/app] 2022-11-09T12:00:01Z 2022-11-09 INFO request handled",
+		}}, false))
+		opts := &args.Args{Query: []string{"foo"}, EntryStart: []string{`^\d{4}-\d{2}-\d{2} `}, Include: []string{"Handler"}}
+		logChan, errChan, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"2022-11-09 ERROR request failed\njava.lang.IllegalStateException: boom\n" +
+				"\tat com.example.Handler.handle(Handler.java:42)",
+		}, collect(t, logChan, errChan, 2))
+	})
+	t.Run("sends pending entries after the group timeout while following", func(t *testing.T) {
+		defer func(d time.Duration) { GroupTimeout = d }(GroupTimeout)
+		GroupTimeout = 10 * time.Millisecond
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {
+			"[pod/foo/app] 2022-11-09T12:00:00Z Error: boom",
+			"[pod/foo/app] 2022-11-09T12:00:00Z     at main (/app/index.js:1:7)",
+		}}, true))
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := &args.Args{Query: []string{"foo"}, Follow: true, Multiline: true}
+		logChan, errChan, err := Read(ctx, opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{"Error: boom\n    at main (/app/index.js:1:7)"}, collect(t, logChan, errChan, 1))
+		cancel()
+		for range logChan {
+		}
+	})
+	t.Run("returns an error for invalid patterns", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		opts := &args.Args{Query: []string{"foo"}, EntryStart: []string{"("}}
+		_, _, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid_entry_start")
+	})
+}
+
+func TestStats(t *testing.T) {
+	t.Run("counts the entries sent for each container", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running", "bar ns Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{
+			"foo": {
+				"[pod/foo/app] 2022-11-09T12:00:00Z INFO one",
+				"[pod/foo/app] 2022-11-09T12:00:01Z ERROR two",
+				"[pod/foo/app] 2022-11-09T12:00:01Z \tat Main.main(Main.java:5)",
+				"[pod/foo/app] 2022-11-09T12:00:02Z healthz",
+				"[pod/foo/sidecar] 2022-11-09T12:00:02Z three",
+			},
+			"bar": {"[pod/bar/app] 2022-11-09T12:00:00Z {\"level\":\"warn\"}"},
+		}, false))
+		opts := &args.Args{Query: []string{"foo", "bar"}, Multiline: true, Exclude: []string{"healthz"}}
+		stats := NewStats()
+		logChan, errChan, err := Read(context.Background(), opts, Kubectl(opts, ex), stats)
+		require.NoError(t, err)
+		require.Len(t, collect(t, logChan, errChan, 5), 4)
+		counters := stats.Summary()
+		for i := range counters {
+			require.Greater(t, counters[i].Rate, 0.0)
+			counters[i].Rate = 0
+		}
+		foo := Counter{Namespace: "default", Pod: "foo", Container: "app", Lines: 3, Bytes: 46}
+		foo.Levels[LevelInfo], foo.Levels[LevelError] = 1, 1
+		sidecar := Counter{Namespace: "default", Pod: "foo", Container: "sidecar", Lines: 1, Bytes: 6}
+		sidecar.Levels[LevelUnknown] = 1
+		bar := Counter{Namespace: "ns", Pod: "bar", Container: "app", Lines: 1, Bytes: 17}
+		bar.Levels[LevelWarn] = 1
+		require.Equal(t, []Counter{foo, sidecar, bar}, counters)
+	})
+	t.Run("calculates rates since the previous snapshot", func(t *testing.T) {
+		stats := NewStats()
+		stats.Observe(&Entry{Pod: "foo", Container: "app", Line: "one"})
+		require.Greater(t, stats.Snapshot()[0].Rate, 0.0)
+		require.Equal(t, 0.0, stats.Snapshot()[0].Rate)
+		require.Greater(t, stats.Summary()[0].Rate, 0.0)
+	})
+}
+
+func TestPatterns(t *testing.T) {
+	t.Run("masks variable parts of messages", func(t *testing.T) {
+		require.Equal(t,
+			"request <uuid> from <ip> took <num>ms for user <hex> at <hex> with status <num> (added)",
+			mask("request 3f2b8c1e-9a4d-4e6f-8b7a-1c2d3e4f5a6b from 10.0.0.12:8080 took 12.5ms for user 5f1b3c9a "+
+				"at 0xc000123 with status 200 (added)"))
+	})
+	t.Run("clusters similar messages into patterns", func(t *testing.T) {
+		p := NewPatterns()
+		var seen []bool
+		for _, e := range []*Entry{
+			{Namespace: "default", Pod: "foo-1", Line: "user alice logged in from 10.0.0.1"},
+			{Namespace: "default", Pod: "foo-2", Line: "user bob logged in from 10.0.0.2"},
+			{Namespace: "default", Pod: "foo-1", Line: "user carol logged in from 10.0.0.3"},
+			{Namespace: "default", Pod: "foo-1", Line: "cache miss for key 42"},
+			{Namespace: "default", Pod: "foo-1", Line: `{"msg":"cache miss for key 7"}`, Record: &Record{Message: "cache miss for key 7"}},
+			{Namespace: "default", Pod: "foo-1", Line: "connection reset by peer"},
+			{Namespace: "default", Pod: "foo-2", Line: "shutting down\n\tat Main.main(Main.java:5)"},
+		} {
+			p.Observe(e)
+			seen = append(seen, e.NewPattern)
+		}
+		require.Equal(t, []bool{true, false, false, true, false, true, true}, seen)
+		require.Equal(t, []Pattern{
+			{Template: "user <*> logged in from <ip>", Count: 3, Pods: []string{"default/foo-1", "default/foo-2"}},
+			{Template: "cache miss for key <num>", Count: 2, Pods: []string{"default/foo-1"}},
+		}, p.Top(2))
+		require.Len(t, p.Top(0), 4)
+		require.Equal(t, "shutting down", p.Top(0)[3].Template)
+	})
+	t.Run("sets the pattern of entries read", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {
+			"[pod/foo/app] 2022-11-09T12:00:00Z GET /api/1 200",
+			"[pod/foo/app] 2022-11-09T12:00:01Z GET /api/2 200",
+		}}, false))
+		opts := &args.Args{Query: []string{"foo"}}
+		logChan, _, err := Read(context.Background(), opts, Kubectl(opts, ex), NewPatterns())
+		require.NoError(t, err)
+		var got []*Entry
+		for e := range logChan {
+			got = append(got, e)
+		}
+		require.Len(t, got, 2)
+		require.True(t, got[0].NewPattern)
+		require.False(t, got[1].NewPattern)
+		require.Equal(t, "GET /api/<num> <num>", got[1].Pattern)
+	})
+}
+
+func TestCollapse(t *testing.T) {
+	// repeats returns the lines of entries read with the number of times they were repeated
+	repeats := func(logChan <-chan *Entry, n int) []string {
+		var got []string
+		for e := range logChan {
+			got = append(got, fmt.Sprintf("%s x%d", e.Line, e.Repeated))
+			if len(got) == n {
+				break
+			}
+		}
+		return got
+	}
+	t.Run("collapses consecutive repeated lines of each container", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {
+			"[pod/foo/app] 2022-11-09T12:00:00Z connecting",
+			"[pod/foo/app] 2022-11-09T12:00:01Z retrying in 1s",
+			"[pod/foo/sidecar] 2022-11-09T12:00:01Z ready",
+			"[pod/foo/app] 2022-11-09T12:00:02Z retrying in 1s",
+			"[pod/foo/app] 2022-11-09T12:00:03Z retrying in 1s",
+			"[pod/foo/app] 2022-11-09T12:00:04Z retrying in 1s",
+			"[pod/foo/app] 2022-11-09T12:00:05Z retrying in 2s",
+			"[pod/foo/app] 2022-11-09T12:00:06Z connected",
+		}}, false))
+		opts := &args.Args{Query: []string{"foo"}, Collapse: true, CollapseAfter: "1s", CollapseMax: "3", Merge: true}
+		logChan, _, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"connecting x1",
+			"retrying in 1s x3",
+			"ready x1",
+			"retrying in 1s x1",
+			"retrying in 2s x1",
+			"connected x1",
+		}, repeats(logChan, -1))
+	})
+	t.Run("collapses lines with the same pattern", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {
+			"[pod/foo/app] 2022-11-09T12:00:01Z retrying in 1s",
+			"[pod/foo/app] 2022-11-09T12:00:02Z retrying in 2s",
+			"[pod/foo/app] 2022-11-09T12:00:03Z retrying in 4s",
+			"[pod/foo/app] 2022-11-09T12:00:06Z connected",
+		}}, false))
+		opts := &args.Args{Query: []string{"foo"}, CollapsePatterns: true, CollapseAfter: "1s"}
+		logChan, _, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{"retrying in 1s x3", "connected x1"}, repeats(logChan, -1))
+	})
+	t.Run("sends repeated lines after the quiet interval while following", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {
+			"[pod/foo/app] 2022-11-09T12:00:01Z retrying",
+			"[pod/foo/app] 2022-11-09T12:00:02Z retrying",
+		}}, true))
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := &args.Args{Query: []string{"foo"}, Follow: true, Collapse: true, CollapseAfter: "10ms"}
+		logChan, _, err := Read(ctx, opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{"retrying x2"}, repeats(logChan, 1))
+		cancel()
+		for range logChan {
+		}
+	})
+	t.Run("returns an error for invalid options", func(t *testing.T) {
+		for _, opts := range []*args.Args{
+			{Query: []string{"foo"}, Collapse: true, CollapseAfter: "soon"},
+			{Query: []string{"foo"}, Collapse: true, CollapseAfter: "1s", CollapseMax: "-1"},
+		} {
+			_, _, err := Read(context.Background(), opts, Kubectl(opts, &mocks.FakeExecutor{}))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "invalid_collapse")
+		}
+	})
+}
+
+func TestSession(t *testing.T) {
+	t.Run("replays recorded lines through the same pipeline", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running", "bar ns Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{
+			"foo": {"[pod/foo/app] 2022-11-09T12:00:00Z one", "[pod/foo/sidecar] 2022-11-09T12:00:01Z two"},
+			"bar": {"[pod/bar/app] 2022-11-09T12:00:00Z three"},
+		}, false))
+		opts := &args.Args{Query: []string{"foo", "bar"}}
+		session := &bytes.Buffer{}
+		backend, flush := RecordSession(Kubectl(opts, ex), session)
+		logChan, errChan, err := Read(context.Background(), opts, backend)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"one", "two", "three"}, collect(t, logChan, errChan, 4))
+		require.NoError(t, flush())
+		require.Contains(t, session.String(), `"namespace":"default","pod":"foo","container":"sidecar","line":"[pod/foo/sidecar] 2022-11-09T12:00:01Z two"`)
+
+		opts = &args.Args{Include: []string{"o"}}
+		backend, err = ReplaySession(opts, bytes.NewReader(session.Bytes()))
+		require.NoError(t, err)
+		logChan, errChan, err = Read(context.Background(), opts, backend)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"one", "two"}, collect(t, logChan, errChan, 3))
+
+		opts = &args.Args{Query: []string{"foo"}, Container: "app"}
+		backend, err = ReplaySession(opts, bytes.NewReader(session.Bytes()))
+		require.NoError(t, err)
+		logChan, errChan, err = Read(context.Background(), opts, backend)
+		require.NoError(t, err)
+		require.Equal(t, []string{"one"}, collect(t, logChan, errChan, 2))
+	})
+	t.Run("keeps the pods of each cluster apart", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {"[pod/foo/app] 2022-11-09T12:00:00Z one"}}, false))
+		opts := &args.Args{Query: []string{"foo"}}
+		session := &bytes.Buffer{}
+		backend, flush := RecordSession(Clusters(map[string]Backend{
+			"prod":    Kubectl(opts, ex),
+			"staging": Kubectl(opts, ex),
+		}), session)
+		logChan, errChan, err := Read(context.Background(), opts, backend)
+		require.NoError(t, err)
+		require.Equal(t, []string{"one", "one"}, collect(t, logChan, errChan, 3))
+		require.NoError(t, flush())
+
+		backend, err = ReplaySession(opts, bytes.NewReader(session.Bytes()))
+		require.NoError(t, err)
+		pods, err := backend.Pods(context.Background())
+		require.NoError(t, err)
+		require.Len(t, pods, 2)
+		logChan, errChan, err = Read(context.Background(), opts, backend)
+		require.NoError(t, err)
+		var got []string
+		for e := range logChan {
+			got = append(got, e.Context+" "+e.Line)
+		}
+		require.ElementsMatch(t, []string{"prod one", "staging one"}, got)
+		select {
+		case err := <-errChan:
+			require.NoError(t, err)
+		default:
+		}
+	})
+	t.Run("replays lines at their original speed", func(t *testing.T) {
+		session := strings.Join([]string{
+			`{"time":"2022-11-09T12:00:00Z","namespace":"default","pod":"foo","container":"app","line":"[pod/foo/app] 2022-11-09T12:00:00Z one"}`,
+			`{"time":"2022-11-09T12:00:00.1Z","namespace":"default","pod":"foo","container":"app","line":"[pod/foo/app] 2022-11-09T12:00:00Z two"}`,
+		}, "\n")
+		opts := &args.Args{Realtime: true}
+		backend, err := ReplaySession(opts, strings.NewReader(session))
+		require.NoError(t, err)
+		start := time.Now()
+		logChan, errChan, err := Read(context.Background(), opts, backend)
+		require.NoError(t, err)
+		require.Equal(t, []string{"one", "two"}, collect(t, logChan, errChan, 3))
+		require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	})
+	t.Run("returns an error for invalid sessions", func(t *testing.T) {
+		_, err := ReplaySession(&args.Args{}, strings.NewReader("{}\nnot json"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid_session")
+	})
+}
+
+func TestFiles(t *testing.T) {
+	t.Run("reads lines from files and stdin", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.log")
+		require.NoError(t, os.WriteFile(path, []byte("2022-11-09T12:00:00.5Z {\"level\":\"warn\",\"msg\":\"one\"}\r\ntwo\n"), 0o644))
+		opts := &args.Args{File: []string{path, "-"}}
+		logChan, errChan, err := Read(context.Background(), opts, Files(opts, strings.NewReader("three\nfour")))
+		require.NoError(t, err)
+		var got []*Entry
+		for len(got) < 4 {
+			select {
+			case err := <-errChan:
+				require.NoError(t, err)
+			case e := <-logChan:
+				got = append(got, e)
+			}
+		}
+		lines := map[string][]string{}
+		for _, e := range got {
+			lines[e.Pod] = append(lines[e.Pod], e.Line)
+		}
+		require.Equal(t, map[string][]string{path: {`{"level":"warn","msg":"one"}`, "two"}, Stdin: {"three", "four"}}, lines)
+		for _, e := range got {
+			if e.Line == `{"level":"warn","msg":"one"}` {
+				require.Equal(t, time.Date(2022, 11, 9, 12, 0, 0, 5e8, time.UTC), e.Time)
+				require.Equal(t, LevelWarn, e.Level)
+			}
+		}
+	})
+	t.Run("returns an error for missing files", func(t *testing.T) {
+		opts := &args.Args{File: []string{filepath.Join(t.TempDir(), "missing.log")}}
+		_, _, err := Read(context.Background(), opts, Files(opts, nil))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "file_error")
+	})
+}
+
+func TestLogDir(t *testing.T) {
+	write := func(t *testing.T, path string, lines ...string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		defer f.Close()
+		for _, l := range lines {
+			_, err = f.WriteString(l + "\n")
+			require.NoError(t, err)
+		}
+	}
+	id := strings.Repeat("ab", 32)
+	t.Run("reads CRI and Docker json-file logs", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, filepath.Join(dir, "pods", "default_web-1_uid", "app", "0.log"),
+			"2022-11-09T12:00:00.1Z stdout P hello ",
+			"2022-11-09T12:00:00.2Z stdout F world",
+			"2022-11-09T12:00:01Z stderr F {\"level\":\"error\",\"msg\":\"boom\"}",
+		)
+		write(t, filepath.Join(dir, "pods", "default_web-1_uid", "proxy", "0.log"), "2022-11-09T12:00:02Z stdout F proxied")
+		write(t, filepath.Join(dir, "containers", "db-0_data_postgres-"+id+".log"),
+			`{"log":"ready\n","stream":"stdout","time":"2022-11-09T12:00:03Z"}`,
+			`{"log":"part","stream":"stdout","time":"2022-11-09T12:00:04Z"}`,
+			`{"log":"ial\n","stream":"stdout","time":"2022-11-09T12:00:04.5Z"}`,
+		)
+		write(t, filepath.Join(dir, "other.txt"), "ignored")
+		opts := &args.Args{LogDir: dir}
+		logChan, errChan, err := Read(context.Background(), opts, LogDir(opts))
+		require.NoError(t, err)
+		var got []string
+		for len(got) < 5 {
+			select {
+			case err := <-errChan:
+				require.NoError(t, err)
+			case e := <-logChan:
+				got = append(got, fmt.Sprintf("%s/%s/%s/%s %s %s", e.Namespace, e.Pod, e.Container, e.Stream, e.Time.Format(time.RFC3339Nano), e.Line))
+			}
+		}
+		require.ElementsMatch(t, []string{
+			"default/web-1/app/stdout 2022-11-09T12:00:00.1Z hello world",
+			`default/web-1/app/stderr 2022-11-09T12:00:01Z {"level":"error","msg":"boom"}`,
+			"default/web-1/proxy/stdout 2022-11-09T12:00:02Z proxied",
+			"data/db-0/postgres/stdout 2022-11-09T12:00:03Z ready",
+			"data/db-0/postgres/stdout 2022-11-09T12:00:04Z partial",
+		}, got)
+
+		opts = &args.Args{LogDir: dir, Query: []string{"web"}, Container: "proxy"}
+		logChan, errChan, err = Read(context.Background(), opts, LogDir(opts))
+		require.NoError(t, err)
+		require.Equal(t, []string{"proxied"}, collect(t, logChan, errChan, 2))
+	})
+	t.Run("follows files as they grow and rotate", func(t *testing.T) {
+		interval := FilePollInterval
+		FilePollInterval = 10 * time.Millisecond
+		defer func() {
+			FilePollInterval = interval
+		}()
+		dir := t.TempDir()
+		current := filepath.Join(dir, "default_web-1_uid", "app", "0.log")
+		write(t, current, "2022-11-09T12:00:00Z stdout F one")
+		ctx, cancel := context.WithCancel(context.Background())
+		opts := &args.Args{LogDir: dir, Follow: true}
+		logChan, errChan, err := Read(ctx, opts, LogDir(opts))
+		require.NoError(t, err)
+		require.Equal(t, []string{"one"}, collect(t, logChan, errChan, 1))
+		write(t, current, "2022-11-09T12:00:01Z stdout F two")
+		require.Equal(t, []string{"two"}, collect(t, logChan, errChan, 1))
+		require.NoError(t, os.Rename(current, current+".20221109-120002"))
+		write(t, current, "2022-11-09T12:00:03Z stdout F three")
+		require.Equal(t, []string{"three"}, collect(t, logChan, errChan, 1))
+		time.Sleep(20 * time.Millisecond)
+		write(t, filepath.Join(dir, "default_web-1_uid", "app", "1.log"), "2022-11-09T12:00:04Z stdout F four")
+		require.Equal(t, []string{"four"}, collect(t, logChan, errChan, 1))
+		cancel()
+		for range logChan {
+		}
+	})
+	t.Run("returns an error for missing directories", func(t *testing.T) {
+		opts := &args.Args{LogDir: filepath.Join(t.TempDir(), "missing")}
+		_, _, err := Read(context.Background(), opts, LogDir(opts))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "log_dir_error")
+	})
+}
+
+func TestDocker(t *testing.T) {
+	t.Run("streams the logs of matching containers", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{
+			"shop-web-1\trunning\tcom.docker.compose.project=shop,com.docker.compose.service=web,tier=front",
+			"shop-db-1\trunning\tcom.docker.compose.project=shop,com.docker.compose.service=db,tier=data",
+			"web-scratch\texited\t",
+			"",
+		}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{
+			"shop-web-1":  {"2022-11-09T12:00:00Z {\"level\":\"info\",\"msg\":\"one\"}"},
+			"shop-db-1":   {"2022-11-09T12:00:00Z two"},
+			"web-scratch": {"2022-11-09T12:00:01Z three"},
+		}, false))
+		opts := &args.Args{Query: []string{"web"}, Tail: "10", Label: []string{"tier!=data"}}
+		logChan, errChan, err := Read(context.Background(), opts, Docker(opts, ex))
+		require.NoError(t, err)
+		var got []string
+		for len(got) < 2 {
+			select {
+			case err := <-errChan:
+				require.NoError(t, err)
+			case e := <-logChan:
+				got = append(got, fmt.Sprintf("%s/%s/%s %s %s", e.Namespace, e.Pod, e.Container, e.Level, e.Line))
+			}
+		}
+		require.ElementsMatch(t, []string{
+			`shop/shop-web-1/web info {"level":"info","msg":"one"}`,
+			"/web-scratch/web-scratch  three",
+		}, got)
+		_, ps := ex.SyncArgsForCall(0)
+		require.Equal(t, []string{"docker", "ps", "--all", "--no-trunc", "--format", "{{.Names}}\t{{.State}}\t{{.Labels}}"}, ps)
+		_, _, logs := ex.StreamArgsForCall(0)
+		require.Subset(t, logs, []string{"docker", "logs", "--timestamps", "--tail", "10"})
+		require.NotContains(t, logs, "--follow")
+	})
+	t.Run("maps the namespace and container options onto compose projects and services", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{
+			"shop-web-1\trunning\tcom.docker.compose.project=shop,com.docker.compose.service=web",
+			"shop-worker-1\tcreated\tcom.docker.compose.project=shop,com.docker.compose.service=worker",
+			"blog-web-1\trunning\tcom.docker.compose.project=blog,com.docker.compose.service=web",
+		}, nil)
+		opts := &args.Args{Namespace: "shop", Container: "web"}
+		pods, err := Docker(opts, ex).Pods(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []*Pod{{Name: "shop-web-1", Namespace: "shop", Phase: "Running"}}, pods)
+		opts = &args.Args{Namespace: "shop"}
+		pods, err = Docker(opts, ex).Pods(context.Background())
+		require.NoError(t, err)
+		require.Len(t, pods, 2)
+		require.Equal(t, "Pending", pods[1].Phase)
+	})
+	t.Run("matches label selectors", func(t *testing.T) {
+		labels := parseLabels("app=web,tier=front,args=a,b")
+		require.Equal(t, map[string]string{"app": "web", "tier": "front", "args": "a,b"}, labels)
+		for selector, want := range map[string]bool{
+			"app=web":             true,
+			"app==web,tier":       true,
+			"app=db":              false,
+			"tier!=data":          true,
+			"!missing,app!=db":    true,
+			"missing":             false,
+			"app=web,tier=back":   false,
+			"app=web, tier=front": true,
+		} {
+			require.Equal(t, want, selects(labels, selector), selector)
+		}
+	})
+}
+
+func TestClusters(t *testing.T) {
+	t.Run("reads the pods of every cluster, reporting the clusters that fail", func(t *testing.T) {
+		prod := &mocks.FakeExecutor{}
+		prod.SyncReturns([]string{"foo default Running"}, nil)
+		prod.StreamCalls(fakeStream(map[string][]string{"foo": {"[pod/foo/app] 2022-11-09T12:00:00Z one"}}, false))
+		staging := &mocks.FakeExecutor{}
+		staging.SyncReturns(nil, context.DeadlineExceeded)
+		opts := &args.Args{Query: []string{"foo"}}
+		logChan, errChan, err := Read(context.Background(), opts, Clusters(map[string]Backend{
+			"prod":    Kubectl(opts, prod),
+			"staging": Kubectl(opts, staging),
+		}))
+		require.NoError(t, err)
+		var got []string
+		for e := range logChan {
+			if e.Notice {
+				got = append(got, "[klogs] "+strings.SplitN(e.Line, ":", 2)[0])
+				continue
+			}
+			got = append(got, e.Context+" "+e.Pod+" "+e.Line)
+		}
+		require.ElementsMatch(t, []string{"prod foo one", "[klogs] unable to list pods of context staging"}, got)
+		select {
+		case err := <-errChan:
+			require.NoError(t, err)
+		default:
+		}
+	})
+	t.Run("keeps the pods of failing clusters and reports each failure once", func(t *testing.T) {
+		prod := &mocks.FakeExecutor{}
+		prod.SyncReturns([]string{"foo default Running"}, nil)
+		staging := &mocks.FakeExecutor{}
+		staging.SyncReturnsOnCall(0, []string{"foo default Running"}, nil)
+		staging.SyncReturns(nil, context.DeadlineExceeded)
+		opts := &args.Args{}
+		b := Clusters(map[string]Backend{"prod": Kubectl(opts, prod), "staging": Kubectl(opts, staging)})
+		pods, err := b.Pods(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []*Pod{
+			{Name: "foo", Namespace: "default", Phase: "Running", Context: "prod"},
+			{Name: "foo", Namespace: "default", Phase: "Running", Context: "staging"},
+		}, pods)
+		require.NotEqual(t, pods[0].key(), pods[1].key())
+		pods, err = b.Pods(context.Background())
+		require.Len(t, pods, 2)
+		var clusterErrs ClusterErrors
+		require.ErrorAs(t, err, &clusterErrs)
+		require.Contains(t, clusterErrs, "staging")
+		pods, err = b.Pods(context.Background())
+		require.NoError(t, err)
+		require.Len(t, pods, 2)
+	})
+	t.Run("reports clusters that can't be connected to", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {"[pod/foo/app] 2022-11-09T12:00:00Z one"}}, false))
+		opts := &args.Args{Query: []string{"foo"}}
+		logChan, _, err := Read(context.Background(), opts, Clusters(map[string]Backend{
+			"prod":    Kubectl(opts, ex),
+			"staging": Failed(fmt.Errorf("unable to load kubeconfig: no CA")),
+		}))
+		require.NoError(t, err)
+		var got []string
+		for e := range logChan {
+			got = append(got, e.Line)
+		}
+		require.ElementsMatch(t, []string{
+			"one",
+			"unable to list pods of context staging: unable to load kubeconfig: no CA",
+		}, got)
+	})
+	t.Run("returns an error if every cluster fails", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns(nil, context.DeadlineExceeded)
+		opts := &args.Args{Query: []string{"foo"}}
+		_, _, err := Read(context.Background(), opts, Clusters(map[string]Backend{
+			"prod":    Kubectl(opts, ex),
+			"staging": Kubectl(opts, ex),
+		}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unable to list pods of context prod")
+		require.Contains(t, err.Error(), "unable to list pods of context staging")
+	})
+}
+
+func TestFilter(t *testing.T) {
+	t.Run("only sends lines matching include patterns and not matching exclude patterns", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		ex.SyncReturns([]string{"foo default Running"}, nil)
+		ex.StreamCalls(fakeStream(map[string][]string{"foo": {
+			"[pod/foo/app] 2022-11-09T12:00:00Z GET /api 200",
+			"[pod/foo/app] 2022-11-09T12:00:01Z GET /healthz 200",
+			"[pod/foo/app] 2022-11-09T12:00:02Z POST /api 500",
+			"[pod/foo/app] 2022-11-09T12:00:03Z starting",
+		}}, false))
+		opts := &args.Args{Query: []string{"foo"}, Include: []string{"GET", "POST"}, Exclude: []string{"healthz"}}
+		logChan, errChan, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.NoError(t, err)
+		require.Equal(t, []string{"GET /api 200", "POST /api 500"}, collect(t, logChan, errChan, 3))
+	})
+	t.Run("returns an error for invalid patterns", func(t *testing.T) {
+		ex := &mocks.FakeExecutor{}
+		opts := &args.Args{Query: []string{"foo"}, Exclude: []string{"("}}
+		_, _, err := Read(context.Background(), opts, Kubectl(opts, ex))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid_filter")
+		require.Equal(t, 0, ex.SyncCallCount())
+	})
+	t.Run("filters JSON entries with a where expression", func(t *testing.T) {
+		for _, keep := range []bool{false, true} {
+			f, err := newFilter(&args.Args{Where: `level == "error" && http.status >= 500`, KeepNonJSON: keep})
+			require.NoError(t, err)
+			p := &Pod{Name: "foo", Namespace: "ns"}
+			require.True(t, f.match(parse(p, `[pod/foo/app] 2022-11-09T12:00:00Z {"level":"error","http":{"status":503}}`)))
+			require.False(t, f.match(parse(p, `[pod/foo/app] 2022-11-09T12:00:00Z {"level":"error","http":{"status":404}}`)))
+			require.True(t, f.match(parse(p, `[pod/foo/app] 2022-11-09T12:00:00Z level=error http.status=503`)))
+			require.Equal(t, keep, f.match(parse(p, `[pod/foo/app] 2022-11-09T12:00:00Z level error`)))
+		}
+		_, err := newFilter(&args.Args{Where: "level =="})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid_filter")
+	})
+	t.Run("drops entries below the minimum level", func(t *testing.T) {
+		f, err := newFilter(&args.Args{Level: "warn"})
+		require.NoError(t, err)
+		require.True(t, f.match(&Entry{Level: LevelError}))
+		require.True(t, f.match(&Entry{Level: LevelWarn}))
+		require.False(t, f.match(&Entry{Level: LevelInfo}))
+		require.False(t, f.match(&Entry{}))
+		_, err = newFilter(&args.Args{Level: "loud"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid_filter")
+	})
+	t.Run("records the merged ranges matched by include patterns", func(t *testing.T) {
+		f, err := newFilter(&args.Args{Include: []string{"o+", "fo", "bar"}})
+		require.NoError(t, err)
+		e := &Entry{Line: "foo bar boo"}
+		require.True(t, f.match(e))
+		require.Equal(t, [][]int{{0, 3}, {4, 7}, {9, 11}}, e.Matches)
+	})
+}
+
+func TestDetectLevel(t *testing.T) {
+	tests := []struct {
+		it    string
+		line  string
+		level Level
+		token string
+	}{
+		{"detects JSON level fields", `{"level":"WARNING","msg":"disk full"}`, LevelWarn, "WARNING"},
+		{"detects JSON severity fields", `{"severity": "ERROR"}`, LevelError, "ERROR"},
+		{"detects JSON lvl fields", `{"lvl":"dbug"}`, LevelUnknown, ""},
+		{"detects numeric pino levels", `{"level":30,"msg":"listening"}`, LevelInfo, "30"},
+		{"detects numeric bunyan levels", `{"level":60,"msg":"crashed"}`, LevelFatal, "60"},
+		{"detects logfmt level keys", `ts=2022-11-09T12:00:00Z level=debug msg="starting"`, LevelDebug, "debug"},
+		{"detects quoted logfmt level keys", `lvl="error" msg=oops`, LevelError, "error"},
+		{"detects klog headers", `W0102 15:04:05.000000       1 reflector.go:424] watch closed`, LevelWarn, "W0102"},
+		{"detects upper case level names", `2022/11/09 12:00:00 [main] ERROR connection refused`, LevelError, "ERROR"},
+		{"ignores lower case words", `an error occurred`, LevelUnknown, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.it, func(t *testing.T) {
+			e := parse(&Pod{Name: "foo"}, "[pod/foo/app] 2022-11-09T12:00:00Z "+tt.line)
+			require.Equal(t, tt.level, e.Level)
+			if tt.token == "" {
+				require.Nil(t, e.LevelSpan)
+			} else {
+				require.Equal(t, tt.token, e.Line[e.LevelSpan[0]:e.LevelSpan[1]])
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	ts := time.Date(2022, 11, 9, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		it       string
+		line     string
+		fieldMap []string
+		want     *Record
+		level    string
+	}{
+		{
+			it:    "normalizes zap entries",
+			line:  `{"level":"error","ts":1667995200,"caller":"main.go:12","msg":"failed","error":"boom","stacktrace":"main.main","id":1}`,
+			want:  &Record{Schema: "zap", Time: ts, Level: LevelError, Message: "failed", Caller: "main.go:12", Error: "boom", Stack: "main.main", Fields: map[string]interface{}{"id": float64(1)}},
+			level: "error",
+		},
+		{
+			it:    "normalizes logrus entries",
+			line:  `{"level":"warning","msg":"slow","time":"2022-11-09T12:00:00Z","error":"timeout"}`,
+			want:  &Record{Schema: "logrus", Time: ts, Level: LevelWarn, Message: "slow", Error: "timeout", Fields: map[string]interface{}{}},
+			level: "warning",
+		},
+		{
+			it:    "normalizes slog entries",
+			line:  `{"time":"2022-11-09T12:00:00Z","level":"INFO","source":{"function":"main.main","file":"main.go","line":7},"msg":"ok"}`,
+			want:  &Record{Schema: "slog", Time: ts, Level: LevelInfo, Message: "ok", Caller: "main.go:7", Fields: map[string]interface{}{}},
+			level: "INFO",
+		},
+		{
+			it:    "normalizes bunyan entries",
+			line:  `{"name":"api","hostname":"h","pid":1,"level":50,"err":{"message":"boom","stack":"Error: boom\n    at x"},"msg":"failed","time":"2022-11-09T12:00:00Z","v":0}`,
+			want:  &Record{Schema: "bunyan", Time: ts, Level: LevelError, Message: "failed", Error: "boom", Stack: "Error: boom\n    at x", Fields: map[string]interface{}{"name": "api", "hostname": "h", "pid": float64(1), "v": float64(0)}},
+			level: "50",
+		},
+		{
+			it:    "normalizes pino entries",
+			line:  `{"level":30,"time":1667995200000,"pid":1,"hostname":"h","msg":"listening"}`,
+			want:  &Record{Schema: "pino", Time: ts, Level: LevelInfo, Message: "listening", Fields: map[string]interface{}{"pid": float64(1), "hostname": "h"}},
+			level: "30",
+		},
+		{
+			it:   "normalizes Serilog entries, which leave out the information level",
+			line: `{"@t":"2022-11-09T12:00:00Z","@mt":"Hello {User}","User":"bob"}`,
+			want: &Record{Schema: "serilog", Time: ts, Level: LevelInfo, Message: "Hello {User}", Fields: map[string]interface{}{"User": "bob"}},
+		},
+		{
+			it:    "normalizes logfmt entries",
+			line:  `ts=2022-11-09T12:00:00Z caller=main.go:3 level=warn msg="disk full" err="no space" path=/data`,
+			want:  &Record{Schema: "logfmt", Time: ts, Level: LevelWarn, Message: "disk full", Caller: "main.go:3", Error: "no space", Fields: map[string]interface{}{"path": "/data"}},
+			level: "warn",
+		},
+		{
+			it:       "uses custom field mappings",
+			line:     `{"when":"2022-11-09T12:00:00Z","sev":"ERROR","event":"failed","message":"other"}`,
+			fieldMap: []string{"msg=event,level=sev", "time=when"},
+			want:     &Record{Schema: "json", Time: ts, Level: LevelError, Message: "failed", Fields: map[string]interface{}{"message": "other"}},
+			level:    "ERROR",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.it, func(t *testing.T) {
+			n, err := newNormalizer(&args.Args{FieldMap: tt.fieldMap})
+			require.NoError(t, err)
+			e := parse(&Pod{Name: "foo"}, "[pod/foo/app] 2022-11-09T12:00:00Z "+tt.line)
+			n.apply(nil, e)
+			e.Record.Time = e.Record.Time.UTC()
+			require.Equal(t, tt.want, e.Record)
+			require.Equal(t, tt.want.Level, e.Level)
+			if tt.level == "" {
+				require.Nil(t, e.LevelSpan)
+			} else {
+				require.Equal(t, tt.level, e.Line[e.LevelSpan[0]:e.LevelSpan[1]])
+			}
+		})
+	}
+	t.Run("reuses the schema recognized for a container", func(t *testing.T) {
+		n, err := newNormalizer(&args.Args{})
+		require.NoError(t, err)
+		s := n.apply(nil, parse(&Pod{}, `[pod/foo/app] 2022-11-09T12:00:00Z {"level":"info","ts":1,"msg":"zap"}`))
+		e := parse(&Pod{}, `[pod/foo/app] 2022-11-09T12:00:00Z {"level":"info","ts":1,"caller":"main.go:1"}`)
+		require.Same(t, s, n.apply(s, e))
+		require.Equal(t, "zap", e.Record.Schema)
+	})
+	t.Run("returns an error for invalid field mappings", func(t *testing.T) {
+		_, err := newNormalizer(&args.Args{FieldMap: []string{"message=event"}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid_field_map")
+	})
+}
+
+func TestEmbeddedJSON(t *testing.T) {
+	p := &Pod{Name: "foo", Namespace: "ns"}
+	t.Run("finds JSON objects embedded in text", func(t *testing.T) {
+		e := parse(p, `[pod/foo/app] 2022-11-09T12:00:00Z 2024-01-01 INFO {a} handler: {"path":"/x","status":200} done`)
+		require.Equal(t, FormatJSON, e.Format)
+		require.Equal(t, map[string]interface{}{"path": "/x", "status": float64(200)}, e.Fields)
+		require.Equal(t, `{"path":"/x","status":200}`, e.Line[e.JSONSpan[0]:e.JSONSpan[1]])
+		require.True(t, e.Embedded())
+		require.Equal(t, LevelInfo, e.Level)
+		require.False(t, parse(p, `[pod/foo/app] 2022-11-09T12:00:00Z {"a":1}`).Embedded())
+	})
+	t.Run("unwraps escaped JSON string values", func(t *testing.T) {
+		for in, want := range map[string]string{
+			`{"msg":"{\"a\":1}","n":" [1, 2]","k":"{not json}","{\"x\":1}":"v"}`: `{"msg":{"a":1},"n":[1,2],"k":"{not json}","{\"x\":1}":"v"}`,
+			`{"outer":"{\"inner\":\"{\\\"a\\\":1}\"}"}`:                          `{"outer":{"inner":{"a":1}}}`,
+			`{"list":["{\"a\":1}", 2]}`:                                          `{"list":[{"a":1}, 2]}`,
+		} {
+			got, ok := unwrapJSON(in)
+			require.True(t, ok, in)
+			require.Equal(t, want, got)
+		}
+		_, ok := unwrapJSON(`{"a":"b"}`)
+		require.False(t, ok)
+	})
+	t.Run("rewrites unwrapped entries", func(t *testing.T) {
+		e := parse(p, `[pod/foo/app] 2022-11-09T12:00:00Z handler: {"level":"warn","body":"{\"id\":1}"} done`)
+		unwrap(e)
+		require.Equal(t, `handler: {"level":"warn","body":{"id":1}} done`, e.Line)
+		require.Equal(t, map[string]interface{}{"level": "warn", "body": map[string]interface{}{"id": float64(1)}}, e.Fields)
+		require.Equal(t, `{"level":"warn","body":{"id":1}}`, e.Line[e.JSONSpan[0]:e.JSONSpan[1]])
+		require.Equal(t, "warn", e.Line[e.LevelSpan[0]:e.LevelSpan[1]])
+	})
+}
+
+func TestParse(t *testing.T) {
+	p := &Pod{Name: "foo", Namespace: "ns"}
+	t.Run("parses the prefix and timestamp of a line", func(t *testing.T) {
+		require.Equal(t, &Entry{
+			Pod:       "foo",
+			Namespace: "ns",
+			Container: "app",
+			Line:      "hello world",
+			Time:      time.Date(2022, 11, 9, 12, 0, 0, 1, time.UTC),
+		}, parse(p, "[pod/foo/app] 2022-11-09T12:00:00.000000001Z hello world"))
+	})
+	t.Run("parses JSON log entries", func(t *testing.T) {
+		e := parse(p, `[pod/foo/app] 2022-11-09T12:00:00Z {"level":"info","n":1,"obj":{"a":true}}`)
+		require.Equal(t, map[string]interface{}{
+			"level": "info",
+			"n":     float64(1),
+			"obj":   map[string]interface{}{"a": true},
+		}, e.Fields)
+		require.Nil(t, parse(p, "[pod/foo/app] 2022-11-09T12:00:00Z {not json").Fields)
+	})
+	t.Run("parses logfmt log entries", func(t *testing.T) {
+		e := parse(p, `[pod/foo/app] 2022-11-09T12:00:00Z level=info msg="hello \"world\"" http.status=200 empty=`)
+		require.Equal(t, FormatLogfmt, e.Format)
+		require.Equal(t, map[string]interface{}{
+			"level":       "info",
+			"msg":         `hello "world"`,
+			"http.status": "200",
+			"empty":       "",
+		}, e.Fields)
+		for _, line := range []string{"a=b", "a=b and more", `a="unterminated b=c`, "=b c=d"} {
+			require.Nil(t, parse(p, "[pod/foo/app] 2022-11-09T12:00:00Z "+line).Fields, line)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package logs
+
+import (
+	"sync"
+	"time"
+)
+
+// MergeWindow is how long entries are held back while following merged logs, so that entries from pods whose logs
+// arrive slightly later can still be put in order
+var MergeWindow = time.Second
+
+// mergeBuffer is the number of entries queued for each stream before it has to wait for them to be merged
+const mergeBuffer = 1000
+
+type queued struct {
+	entry   *Entry
+	arrived time.Time
+}
+
+type mergeQueue struct {
+	entries []queued
+	done    bool
+}
+
+// merger performs a k-way merge of the entries of each stream by timestamp. An entry is released once every stream has
+// an entry queued or has ended, so backlogs are merged exactly. While following, streams may go quiet indefinitely, so
+// entries are also released once they have waited for MergeWindow
+type merger struct {
+	follow   bool
+	out      chan<- *Entry
+	mu       sync.Mutex
+	space    *sync.Cond
+	queues   map[*podStream]*mergeQueue
+	closed   bool
+	wake     chan struct{}
+	finished chan struct{}
+}
+
+func newMerger(out chan<- *Entry, follow bool) *merger {
+	m := &merger{
+		follow:   follow,
+		out:      out,
+		queues:   map[*podStream]*mergeQueue{},
+		wake:     make(chan struct{}, 1),
+		finished: make(chan struct{}),
+	}
+	m.space = sync.NewCond(&m.mu)
+	go m.run()
+	return m
+}
+
+func (m *merger) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// add registers a stream, which holds back all entries until the stream has an entry queued or is done
+func (m *merger) add(ps *podStream) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queues[ps] = &mergeQueue{}
+}
+
+// done marks a stream as ended
+func (m *merger) done(ps *podStream) {
+	m.mu.Lock()
+	m.queues[ps].done = true
+	m.mu.Unlock()
+	m.signal()
+}
+
+// close marks that no more streams will be added. The merger finishes once all queued entries have been sent
+func (m *merger) close() {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.signal()
+}
+
+// push queues an entry from a stream, keeping the queue in timestamp order since containers of the same pod may be
+// interleaved
+func (m *merger) push(ps *podStream, e *Entry) {
+	m.mu.Lock()
+	q := m.queues[ps]
+	for len(q.entries) >= mergeBuffer {
+		m.space.Wait()
+	}
+	i := len(q.entries)
+	for i > 0 && e.Time.Before(q.entries[i-1].entry.Time) {
+		i--
+	}
+	q.entries = append(q.entries, queued{})
+	copy(q.entries[i+1:], q.entries[i:])
+	q.entries[i] = queued{entry: e, arrived: time.Now()}
+	m.mu.Unlock()
+	m.signal()
+}
+
+// next removes and returns the earliest queued entry if it can be released, otherwise it returns how long to wait
+// before checking again, or a negative duration to wait until signalled
+func (m *merger) next() (*Entry, time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var (
+		earliest *mergeQueue
+		waiting  bool
+	)
+	for ps, q := range m.queues {
+		if len(q.entries) == 0 {
+			if q.done {
+				delete(m.queues, ps)
+			} else {
+				waiting = true
+			}
+			continue
+		}
+		if earliest == nil || q.entries[0].entry.Time.Before(earliest.entries[0].entry.Time) {
+			earliest = q
+		}
+	}
+	if earliest == nil {
+		return nil, -1, m.closed && len(m.queues) == 0
+	}
+	head := earliest.entries[0]
+	if waiting {
+		if !m.follow {
+			return nil, -1, false
+		}
+		if wait := time.Until(head.arrived.Add(MergeWindow)); wait > 0 {
+			return nil, wait, false
+		}
+	}
+	earliest.entries = earliest.entries[1:]
+	m.space.Broadcast()
+	return head.entry, 0, false
+}
+
+func (m *merger) run() {
+	defer close(m.finished)
+	for {
+		e, wait, finished := m.next()
+		if finished {
+			return
+		}
+		if e != nil {
+			m.out <- e
+			continue
+		}
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-m.wake:
+		case <-timer:
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package logs
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ryantate13/klogs/args"
+)
+
+// GroupTimeout is how long a multi-line entry is held back while following, waiting for more continuation lines,
+// before it is sent
+var GroupTimeout = 250 * time.Millisecond
+
+// detector recognizes the continuation lines of the multi-line entries written by a language runtime, such as stack
+// traces
+type detector struct {
+	name string
+	// level is given to grouped entries whose first line has no detectable level
+	level Level
+	// continues reports whether line continues a group, given the first and last lines of the group
+	continues func(first, last, line string) bool
+}
+
+const pythonTraceback = "Traceback (most recent call last):"
+
+var (
+	goPanic     = regexp.MustCompile(`^(panic: |fatal error: )`)
+	goFrame     = regexp.MustCompile(`^(goroutine \d+ |\[signal |created by |\S+\(.*\)$)`)
+	pythonChain = regexp.MustCompile(`^(` + regexp.QuoteMeta(pythonTraceback) +
+		`|During handling of the above exception, another exception occurred:` +
+		`|The above exception was the direct cause of the following exception:)$`)
+	stackFrame = regexp.MustCompile(`^\s+(at |from |\.\.\. \d+ (more|common frames omitted)|--- End of )` +
+		`|^(Caused by|\s*Suppressed): `)
+)
+
+func indented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// detectors are the built-in detectors, in the order they are tried
+var detectors = []*detector{
+	{
+		// goroutine dumps of panics and fatal errors, which are separated by blank lines
+		name:  "go",
+		level: LevelFatal,
+		continues: func(first, _, line string) bool {
+			return goPanic.MatchString(first) && (line == "" || indented(line) || goFrame.MatchString(line))
+		},
+	},
+	{
+		// tracebacks, ending with the unindented exception line, and the tracebacks of any chained exceptions
+		name:  "python",
+		level: LevelError,
+		continues: func(first, last, line string) bool {
+			if strings.TrimSpace(first) != pythonTraceback {
+				return false
+			}
+			return indented(line) || indented(last) || line == "" || pythonChain.MatchString(line)
+		},
+	},
+	{
+		// stack frames of Java, JavaScript, .NET and Ruby exceptions, which follow the exception message
+		name:  "stack",
+		level: LevelError,
+		continues: func(_, _, line string) bool {
+			return stackFrame.MatchString(line)
+		},
+	},
+}
+
+// grouper joins continuation lines onto the line that started their entry. Lines matching one of the custom start
+// patterns start a new entry and all others continue the previous one. Without custom patterns, the built-in
+// detectors recognize continuation lines
+type grouper struct {
+	starts []*regexp.Regexp
+}
+
+// newGrouper returns a grouper for the multi-line options, or nil if lines aren't grouped
+func newGrouper(opts *args.Args) (*grouper, error) {
+	if !opts.Multiline && len(opts.EntryStart) == 0 {
+		return nil, nil
+	}
+	starts, err := compile("invalid_entry_start", opts.EntryStart)
+	if err != nil {
+		return nil, err
+	}
+	return &grouper{starts: starts}, nil
+}
+
+// continues reports whether a line continues a group, and the detector that recognized it, if any
+func (g *grouper) continues(grp *group, line string) (bool, *detector) {
+	if len(g.starts) > 0 {
+		for _, re := range g.starts {
+			if re.MatchString(line) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	for _, d := range detectors {
+		if d.continues(grp.first, grp.last, line) {
+			return true, d
+		}
+	}
+	return false, nil
+}
+
+// group is a multi-line entry that may still have continuation lines to come
+type group struct {
+	entry       *Entry
+	first, last string
+	detector    *detector
+	updated     time.Time
+}
+
+// finish returns the grouped entry, with the level of the runtime that wrote it if its first line has none
+func (grp *group) finish() *Entry {
+	if grp.detector != nil && grp.entry.Level == LevelUnknown {
+		grp.entry.Level = grp.detector.level
+	}
+	return grp.entry
+}
+
+// add joins an entry onto the pending group of its container if it is a continuation line, returning the group it
+// replaced as pending if it starts a new one
+func (ps *podStream) add(g *grouper, e *Entry) *Entry {
+	key := streamKey(e)
+	grp, ok := ps.groups[key]
+	if ok {
+		if continues, d := g.continues(grp, e.Line); continues {
+			grp.entry.Line += "\n" + e.Line
+			grp.last, grp.updated = e.Line, time.Now()
+			if grp.detector == nil {
+				grp.detector = d
+			}
+			return nil
+		}
+	}
+	ps.groups[key] = &group{entry: e, first: e.Line, last: e.Line, updated: time.Now()}
+	if !ok {
+		return nil
+	}
+	return grp.finish()
+}
+
+// expireGroups removes and returns the pending groups that haven't been updated for GroupTimeout as of now, or all of
+// them if now is zero, along with how long until the next one expires
+func (ps *podStream) expireGroups(now time.Time) ([]*Entry, time.Duration) {
+	var (
+		expired []*Entry
+		next    time.Duration
+	)
+	for key, grp := range ps.groups {
+		wait := grp.updated.Add(GroupTimeout).Sub(now)
+		if now.IsZero() || wait <= 0 {
+			expired = append(expired, grp.finish())
+			delete(ps.groups, key)
+		} else if next == 0 || wait < next {
+			next = wait
+		}
+	}
+	sortByTime(expired)
+	return expired, next
+}
+
+// streamKey identifies the container stream an entry was written to
+func streamKey(e *Entry) string {
+	return e.Container + "/" + e.Stream
+}
+
+func sortByTime(entries []*Entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+}
@@ -0,0 +1,161 @@
+package logs
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PatternSimilarity is the fraction of tokens a message must share with a pattern to be clustered into it
+var PatternSimilarity = 0.5
+
+// wildcard replaces the tokens that differ between the messages of a pattern
+const wildcard = "<*>"
+
+// masks replace the variable parts of messages that are recognizable on their own, in order
+var masks = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`), "<hex>"},
+	{hexID, "<hex>"},
+	{regexp.MustCompile(`\d+(\.\d+)?`), "<num>"},
+}
+
+// hexID matches words of at least six hex digits. Only those containing both digits and letters are masked as hex ids,
+// since the others are either numbers or words
+var hexID = regexp.MustCompile(`\b[0-9a-fA-F]{6,}\b`)
+
+// Pattern is a template of similar log messages, with the parts that vary between them masked
+type Pattern struct {
+	Template string
+	Count    int
+	// Pods holds the namespaces and names of the pods that logged messages matching the pattern, sorted
+	Pods []string
+}
+
+type cluster struct {
+	tokens []string
+	count  int
+	pods   map[string]bool
+}
+
+// Patterns clusters log messages into patterns, similar to the Drain algorithm. Messages are masked and split into
+// tokens, then compared with the patterns that have the same number of tokens and first token, and clustered into the
+// most similar one if they share enough tokens with it. Tokens that differ are replaced with a wildcard. It is safe for
+// concurrent use
+type Patterns struct {
+	mu sync.Mutex
+	// clusters are grouped by the number of tokens and first token of their messages
+	clusters map[string][]*cluster
+}
+
+// NewPatterns returns Patterns with no messages clustered
+func NewPatterns() *Patterns {
+	return &Patterns{clusters: map[string][]*cluster{}}
+}
+
+// mask replaces the UUIDs, IP addresses, hex ids and numbers in a message
+func mask(msg string) string {
+	for _, m := range masks {
+		msg = m.re.ReplaceAllStringFunc(msg, func(s string) string {
+			if m.re == hexID && (!strings.ContainsAny(s, "0123456789") || strings.Trim(s, "0123456789") == "") {
+				return s
+			}
+			return m.replacement
+		})
+	}
+	return msg
+}
+
+// message returns the message of an entry to cluster, which is the normalized message of structured entries, or the
+// first line of the entry
+func message(e *Entry) string {
+	if e.Record != nil && e.Record.Message != "" {
+		return e.Record.Message
+	}
+	first, _, _ := strings.Cut(e.Line, "\n")
+	return first
+}
+
+// similarity returns the fraction of tokens that are equal to those of the cluster, ignoring wildcards
+func (c *cluster) similarity(tokens []string) float64 {
+	if len(tokens) == 0 {
+		return 1
+	}
+	same := 0
+	for i, t := range tokens {
+		if c.tokens[i] == t && t != wildcard {
+			same++
+		}
+	}
+	return float64(same) / float64(len(tokens))
+}
+
+// Observe clusters the message of an entry, setting its Pattern and whether the pattern is new
+func (p *Patterns) Observe(e *Entry) {
+	tokens := strings.Fields(mask(message(e)))
+	key := strconv.Itoa(len(tokens))
+	if len(tokens) > 0 && !strings.ContainsRune(tokens[0], '<') {
+		key += " " + tokens[0]
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var (
+		best       *cluster
+		similarity float64
+	)
+	for _, c := range p.clusters[key] {
+		if s := c.similarity(tokens); s >= PatternSimilarity && (best == nil || s > similarity) {
+			best, similarity = c, s
+		}
+	}
+	if best == nil {
+		best = &cluster{tokens: tokens, pods: map[string]bool{}}
+		p.clusters[key] = append(p.clusters[key], best)
+		e.NewPattern = true
+	}
+	for i, t := range tokens {
+		if best.tokens[i] != t {
+			best.tokens[i] = wildcard
+		}
+	}
+	best.count++
+	pod := e.Namespace + "/" + e.Pod
+	if e.Context != "" {
+		pod = e.Context + "/" + pod
+	}
+	best.pods[pod] = true
+	e.Pattern = strings.Join(best.tokens, " ")
+}
+
+// Top returns the n patterns with the most messages, or all of them if n is not positive, in descending order of count
+func (p *Patterns) Top(n int) []Pattern {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var patterns []Pattern
+	for _, clusters := range p.clusters {
+		for _, c := range clusters {
+			pods := make([]string, 0, len(c.pods))
+			for pod := range c.pods {
+				pods = append(pods, pod)
+			}
+			sort.Strings(pods)
+			patterns = append(patterns, Pattern{Template: strings.Join(c.tokens, " "), Count: c.count, Pods: pods})
+		}
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if patterns[i].Count != patterns[j].Count {
+			return patterns[i].Count > patterns[j].Count
+		}
+		return patterns[i].Template < patterns[j].Template
+	})
+	if n > 0 && len(patterns) > n {
+		patterns = patterns[:n]
+	}
+	return patterns
+}
@@ -0,0 +1,390 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/expr"
+)
+
+// Record is a structured log entry normalized from the schema of one of the common logging libraries
+type Record struct {
+	// Schema is the name of the schema the entry was recognized as, such as "zap" or "pino"
+	Schema  string
+	Time    time.Time
+	Level   Level
+	Message string
+	Caller  string
+	Error   string
+	Stack   string
+	// Fields holds the fields of the entry that aren't one of the common fields
+	Fields map[string]interface{}
+}
+
+// roles are the common fields of a Record that can be mapped to fields of a log entry
+var roles = []string{"time", "level", "msg", "caller", "error", "stack"}
+
+// schema describes where a logging library writes each of the common fields
+type schema struct {
+	name   string
+	detect func(fields map[string]interface{}) bool
+	// keys holds the candidate keys for each role, in order of preference
+	keys map[string][]string
+	// level is used for entries that don't have a level field, since some libraries leave it out for the default level
+	level Level
+	// logfmt is true for the schema of logfmt entries, which is used for all of them rather than detected
+	logfmt bool
+	// levelRe locates the value of each level key in the line
+	levelRe map[string]*regexp.Regexp
+}
+
+func has(fields map[string]interface{}, keys ...string) bool {
+	for _, k := range keys {
+		if _, ok := fields[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumber(v interface{}) bool {
+	_, ok := v.(float64)
+	return ok
+}
+
+// schemas are the built-in schemas, in the order they are tried. The last one matches any JSON object
+var schemas = []*schema{
+	{
+		name: "serilog",
+		detect: func(f map[string]interface{}) bool {
+			return has(f, "@t") || has(f, "Timestamp", "MessageTemplate")
+		},
+		keys: map[string][]string{
+			"time":  {"@t", "Timestamp"},
+			"level": {"@l", "Level"},
+			"msg":   {"@m", "RenderedMessage", "@mt", "MessageTemplate"},
+			"stack": {"@x", "Exception"},
+		},
+		level: LevelInfo,
+	},
+	{
+		name: "bunyan",
+		detect: func(f map[string]interface{}) bool {
+			return has(f, "v", "hostname", "pid") && isNumber(f["level"])
+		},
+		keys: map[string][]string{
+			"time":   {"time"},
+			"level":  {"level"},
+			"msg":    {"msg"},
+			"caller": {"src"},
+			"error":  {"err"},
+			"stack":  {"err"},
+		},
+	},
+	{
+		name: "pino",
+		detect: func(f map[string]interface{}) bool {
+			return isNumber(f["level"]) && isNumber(f["time"])
+		},
+		keys: map[string][]string{
+			"time":   {"time"},
+			"level":  {"level"},
+			"msg":    {"msg"},
+			"caller": {"caller"},
+			"error":  {"err"},
+			"stack":  {"err"},
+		},
+	},
+	{
+		name: "zap",
+		detect: func(f map[string]interface{}) bool {
+			return has(f, "ts", "msg") || has(f, "ts", "caller")
+		},
+		keys: map[string][]string{
+			"time":   {"ts"},
+			"level":  {"level"},
+			"msg":    {"msg"},
+			"caller": {"caller"},
+			"error":  {"error"},
+			"stack":  {"stacktrace"},
+		},
+	},
+	{
+		name: "slog",
+		detect: func(f map[string]interface{}) bool {
+			level, _ := f["level"].(string)
+			return has(f, "time", "level", "msg") && (has(f, "source") || level == strings.ToUpper(level))
+		},
+		keys: map[string][]string{
+			"time":   {"time"},
+			"level":  {"level"},
+			"msg":    {"msg"},
+			"caller": {"source"},
+			"error":  {"err", "error"},
+		},
+	},
+	{
+		name: "logrus",
+		detect: func(f map[string]interface{}) bool {
+			return has(f, "time", "level", "msg")
+		},
+		keys: map[string][]string{
+			"time":   {"time"},
+			"level":  {"level"},
+			"msg":    {"msg"},
+			"caller": {"file"},
+			"error":  {"error"},
+		},
+	},
+	{
+		name: "json",
+		detect: func(map[string]interface{}) bool {
+			return true
+		},
+		keys: map[string][]string{
+			"time":   {"time", "ts", "timestamp", "@timestamp"},
+			"level":  {"level", "severity", "lvl", "loglevel"},
+			"msg":    {"msg", "message"},
+			"caller": {"caller", "source"},
+			"error":  {"error", "err"},
+			"stack":  {"stack", "stacktrace", "stack_trace"},
+		},
+	},
+}
+
+// logfmtSchema covers the keys used by logfmt loggers such as go-kit and logrus' text formatter
+var logfmtSchema = &schema{
+	name:   "logfmt",
+	logfmt: true,
+	keys: map[string][]string{
+		"time":   {"ts", "time", "t"},
+		"level":  {"level", "lvl", "severity"},
+		"msg":    {"msg", "message"},
+		"caller": {"caller", "source"},
+		"error":  {"err", "error"},
+		"stack":  {"stack", "stacktrace"},
+	},
+}
+
+func init() {
+	for _, s := range append(schemas, logfmtSchema) {
+		s.compile()
+	}
+}
+
+func (s *schema) compile() {
+	s.levelRe = map[string]*regexp.Regexp{}
+	for _, k := range s.keys["level"] {
+		if s.logfmt {
+			s.levelRe[k] = regexp.MustCompile(`(?:^|\s)` + regexp.QuoteMeta(k) + `="?([^"\s]+)`)
+		} else {
+			s.levelRe[k] = regexp.MustCompile(`"` + regexp.QuoteMeta(k) + `"\s*:\s*"?([^",}\s]+)`)
+		}
+	}
+}
+
+// normalizer recognizes the schema of JSON entries and normalizes them into Records. Custom field mappings take
+// precedence over the fields of the built-in schemas
+type normalizer struct {
+	schemas []*schema
+	logfmt  *schema
+}
+
+func newNormalizer(opts *args.Args) (*normalizer, error) {
+	if len(opts.FieldMap) == 0 {
+		return &normalizer{schemas, logfmtSchema}, nil
+	}
+	custom := map[string][]string{}
+	for _, m := range opts.FieldMap {
+		for _, pair := range strings.Split(m, ",") {
+			role, key, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			valid := false
+			for _, r := range roles {
+				valid = valid || r == role
+			}
+			if !ok || !valid || key == "" {
+				return nil, mkError(map[string]interface{}{
+					"code":    "invalid_field_map",
+					"mapping": pair,
+					"error":   fmt.Sprintf("expected <field>=<key> where field is one of %s", strings.Join(roles, ", ")),
+				})
+			}
+			custom[role] = append(custom[role], key)
+		}
+	}
+	withCustom := func(s *schema) *schema {
+		c := *s
+		c.keys = map[string][]string{}
+		for _, role := range roles {
+			c.keys[role] = append(append([]string{}, custom[role]...), s.keys[role]...)
+		}
+		c.compile()
+		return &c
+	}
+	n := &normalizer{logfmt: withCustom(logfmtSchema)}
+	for _, s := range schemas {
+		n.schemas = append(n.schemas, withCustom(s))
+	}
+	return n, nil
+}
+
+// Normalize converts the fields of a JSON or logfmt entry into a Record using the built-in schemas
+func Normalize(fields map[string]interface{}, format string) *Record {
+	r, _, _ := (&normalizer{schemas, logfmtSchema}).normalize(nil, fields, format)
+	return r
+}
+
+// normalize converts the fields of an entry into a Record using the cached schema if it still applies, returning the
+// schema used and the key the level was read from
+func (n *normalizer) normalize(cached *schema, fields map[string]interface{}, format string) (*Record, *schema, string) {
+	s := cached
+	if format == FormatLogfmt {
+		s = n.logfmt
+	} else if s == nil || s.logfmt || !s.detect(fields) {
+		for _, s = range n.schemas {
+			if s.detect(fields) {
+				break
+			}
+		}
+	}
+	rec := &Record{Schema: s.name, Fields: map[string]interface{}{}}
+	for k, v := range fields {
+		rec.Fields[k] = v
+	}
+	used := func(role string, convert func(interface{}) bool) string {
+		for _, k := range s.keys[role] {
+			v, ok := expr.Lookup(fields, k)
+			if ok && convert(v) {
+				delete(rec.Fields, k)
+				return k
+			}
+		}
+		return ""
+	}
+	used("time", func(v interface{}) bool {
+		rec.Time = parseTime(v)
+		return !rec.Time.IsZero()
+	})
+	used("msg", func(v interface{}) bool {
+		rec.Message, _ = v.(string)
+		return rec.Message != ""
+	})
+	used("caller", func(v interface{}) bool {
+		rec.Caller = caller(v)
+		return rec.Caller != ""
+	})
+	used("error", func(v interface{}) bool {
+		rec.Error = errorMessage(v)
+		return rec.Error != ""
+	})
+	used("stack", func(v interface{}) bool {
+		rec.Stack = stack(v)
+		return rec.Stack != ""
+	})
+	if rec.Error == "" && rec.Stack != "" {
+		rec.Error, _, _ = strings.Cut(rec.Stack, "\n")
+	}
+	levelKey := used("level", func(v interface{}) bool {
+		rec.Level = toLevel(v)
+		return rec.Level != LevelUnknown
+	})
+	if levelKey == "" {
+		rec.Level = s.level
+	}
+	return rec, s, levelKey
+}
+
+// apply normalizes a JSON or logfmt entry, setting its Record and level
+func (n *normalizer) apply(cached *schema, e *Entry) *schema {
+	if e.Fields == nil {
+		return cached
+	}
+	rec, s, levelKey := n.normalize(cached, e.Fields, e.Format)
+	e.Record = rec
+	if rec.Level != LevelUnknown {
+		e.Level, e.LevelSpan = rec.Level, nil
+		if re, ok := s.levelRe[levelKey]; ok {
+			if m := re.FindStringSubmatchIndex(e.Line); m != nil {
+				e.LevelSpan = m[2:4]
+			}
+		}
+	}
+	return s
+}
+
+func toLevel(v interface{}) Level {
+	switch v := v.(type) {
+	case string:
+		l, _ := ParseLevel(v)
+		return l
+	case float64:
+		return numericLevel(v)
+	}
+	return LevelUnknown
+}
+
+// parseTime reads a timestamp written as an RFC 3339 string or as a number of seconds or milliseconds since the epoch
+func parseTime(v interface{}) time.Time {
+	switch v := v.(type) {
+	case string:
+		t, _ := time.Parse(time.RFC3339Nano, v)
+		return t
+	case float64:
+		if v <= 0 {
+			return time.Time{}
+		}
+		if v > 1e12 {
+			v /= 1000
+		}
+		sec, frac := math.Modf(v)
+		return time.Unix(int64(sec), int64(frac*1e9))
+	}
+	return time.Time{}
+}
+
+// caller formats a caller written as a string or as an object with file and line fields, as slog and bunyan do
+func caller(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		file, _ := v["file"].(string)
+		if line, ok := v["line"].(float64); ok && file != "" {
+			return fmt.Sprintf("%s:%d", file, int(line))
+		}
+		return file
+	}
+	return ""
+}
+
+// errorMessage reads an error written as a string or as an object with a message, as bunyan and pino do
+func errorMessage(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if msg, ok := v["message"].(string); ok {
+			return msg
+		}
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return ""
+}
+
+// stack reads a stack trace written as a string or as the stack of an error object
+func stack(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		s, _ := v["stack"].(string)
+		return s
+	}
+	return ""
+}
@@ -0,0 +1,181 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ryantate13/klogs/args"
+)
+
+// recorded is a line from a Backend as written to a session file, with the pod it was streamed for, the kubeconfig
+// context of its cluster when reading from several, and the time it was received
+type recorded struct {
+	Time      time.Time `json:"time"`
+	Context   string    `json:"context,omitempty"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container,omitempty"`
+	Line      string    `json:"line"`
+}
+
+type recorder struct {
+	backend Backend
+	mu      sync.Mutex
+	w       *bufio.Writer
+	enc     *json.Encoder
+	err     error
+}
+
+// watchingRecorder records the lines of a backend that is also a Watcher, so that recording doesn't turn watching into
+// polling
+type watchingRecorder struct {
+	*recorder
+	Watcher
+}
+
+// RecordSession returns a Backend that writes every line streamed by backend to w as a session that can be replayed
+// with ReplaySession, along with a func that flushes the session and returns the first error writing it
+func RecordSession(backend Backend, w io.Writer) (Backend, func() error) {
+	bw := bufio.NewWriter(w)
+	r := &recorder{backend: backend, w: bw, enc: json.NewEncoder(bw)}
+	if watcher, ok := backend.(Watcher); ok {
+		return &watchingRecorder{r, watcher}, r.flush
+	}
+	return r, r.flush
+}
+
+func (r *recorder) Pods(ctx context.Context) ([]*Pod, error) {
+	return r.backend.Pods(ctx)
+}
+
+func (r *recorder) Logs(ctx context.Context, errChan chan<- error, p *Pod, sinceTime string) (<-chan string, error) {
+	c, err := r.backend.Logs(ctx, errChan, p, sinceTime)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for line := range c {
+			r.write(p, line)
+			ch <- line
+		}
+	}()
+	return ch, nil
+}
+
+// write appends a line to the session, keeping the first error so that it can be reported once recording ends
+func (r *recorder) write(p *Pod, line string) {
+	prefix, _, _ := split(line)
+	rec := recorded{Time: time.Now().UTC(), Context: p.Context, Namespace: p.Namespace, Pod: p.Name, Line: line}
+	rec.Container, _ = source(prefix)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(rec); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *recorder) flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.w.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+type replay struct {
+	opts  *args.Args
+	pods  []*Pod
+	lines map[string][]recorded
+	// first is the time the first line of the session was received, and start the time replaying began, which are
+	// compared to pace lines at their original speed
+	first time.Time
+	once  sync.Once
+	start time.Time
+}
+
+// ReplaySession returns a Backend that streams the lines of a session written by RecordSession as if they came from the
+// cluster. Pods are listed in the order their first line was recorded, filtered by the namespace and container options.
+// If opts.Realtime is set, lines are streamed as far apart as they were received, otherwise as fast as they are read
+func ReplaySession(opts *args.Args, r io.Reader) (Backend, error) {
+	rp := &replay{opts: opts, lines: map[string][]recorded{}}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var rec recorded
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, mkError(map[string]interface{}{
+				"code":  "invalid_session",
+				"line":  n,
+				"error": err.Error(),
+			})
+		}
+		if opts.Namespace != "" && !opts.AllNamespaces && rec.Namespace != opts.Namespace {
+			continue
+		}
+		if opts.Container != "" && rec.Container != opts.Container {
+			continue
+		}
+		p := &Pod{Name: rec.Pod, Namespace: rec.Namespace, Phase: "Succeeded", Context: rec.Context}
+		if _, ok := rp.lines[p.key()]; !ok {
+			rp.pods = append(rp.pods, p)
+		}
+		if rp.first.IsZero() || rec.Time.Before(rp.first) {
+			rp.first = rec.Time
+		}
+		rp.lines[p.key()] = append(rp.lines[p.key()], rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, mkError(map[string]interface{}{
+			"code":  "invalid_session",
+			"error": err.Error(),
+		})
+	}
+	return rp, nil
+}
+
+// Pods returns the recorded pods, which are reported as no longer running so that their streams end with the session
+func (rp *replay) Pods(context.Context) ([]*Pod, error) {
+	pods := make([]*Pod, len(rp.pods))
+	for i, p := range rp.pods {
+		pods[i] = &Pod{Name: p.Name, Namespace: p.Namespace, Phase: p.Phase, Context: p.Context}
+	}
+	return pods, nil
+}
+
+func (rp *replay) Logs(ctx context.Context, errChan chan<- error, p *Pod, _ string) (<-chan string, error) {
+	rp.once.Do(func() {
+		rp.start = time.Now()
+	})
+	lines := rp.lines[p.key()]
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		for _, rec := range lines {
+			if rp.opts.Realtime {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Until(rp.start.Add(rec.Time.Sub(rp.first)))):
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- rec.Line:
+			}
+		}
+		errChan <- nil
+	}()
+	return ch, nil
+}
@@ -0,0 +1,93 @@
+package logs
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Counter holds the counts of the entries sent for a container
+type Counter struct {
+	Namespace, Pod, Container string
+	// Context is the kubeconfig context of the cluster of the container, when reading from several clusters
+	Context string
+	// Lines counts the lines of each entry, so multi-line entries count once for each of their lines
+	Lines int
+	// Bytes counts the bytes of each entry, including a newline
+	Bytes int
+	// Levels counts entries by their detected level, indexed by Level
+	Levels [LevelFatal + 1]int
+	// Rate is the number of lines per second
+	Rate float64
+}
+
+// Stats counts the entries sent for each container while reading logs. It is safe for concurrent use
+type Stats struct {
+	mu       sync.Mutex
+	started  time.Time
+	counters map[string]*Counter
+	// sampled is when the last Snapshot was taken, and sampledLines the line counts of each container at the time
+	sampled      time.Time
+	sampledLines map[string]int
+}
+
+// NewStats returns Stats with no entries counted, timing rates from now
+func NewStats() *Stats {
+	now := time.Now()
+	return &Stats{started: now, sampled: now, counters: map[string]*Counter{}, sampledLines: map[string]int{}}
+}
+
+// Observe counts an entry
+func (s *Stats) Observe(e *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := e.Context + "/" + e.Namespace + "/" + e.Pod + "/" + e.Container
+	c, ok := s.counters[key]
+	if !ok {
+		c = &Counter{Namespace: e.Namespace, Pod: e.Pod, Container: e.Container, Context: e.Context}
+		s.counters[key] = c
+	}
+	c.Lines += strings.Count(e.Line, "\n") + 1
+	c.Bytes += len(e.Line) + 1
+	c.Levels[e.Level]++
+}
+
+// counts returns copies of the counters sorted by namespace, pod and container, with rates calculated from the lines
+// counted since the given counts were taken
+func (s *Stats) counts(since time.Time, lines map[string]int) []Counter {
+	elapsed := time.Since(since).Seconds()
+	counters := make([]Counter, 0, len(s.counters))
+	keys := make([]string, 0, len(s.counters))
+	for k := range s.counters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c := *s.counters[k]
+		if elapsed > 0 {
+			c.Rate = float64(c.Lines-lines[k]) / elapsed
+		}
+		counters = append(counters, c)
+	}
+	return counters
+}
+
+// Snapshot returns the counters of each container, with the rate of lines since the previous snapshot
+func (s *Stats) Snapshot() []Counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counters := s.counts(s.sampled, s.sampledLines)
+	s.sampled = time.Now()
+	for k, c := range s.counters {
+		s.sampledLines[k] = c.Lines
+	}
+	return counters
+}
+
+// Summary returns the counters of each container, with the average rate of lines since the stats were created
+func (s *Stats) Summary() []Counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts(s.started, nil)
+}
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/alecthomas/chroma/quick"
 	"github.com/alecthomas/chroma/styles"
@@ -18,16 +19,26 @@ import (
 	term "github.com/jwalton/go-supportscolor"
 	"github.com/mattn/go-isatty"
 
+	"github.com/ryantate13/klogs/archive"
 	"github.com/ryantate13/klogs/args"
 	"github.com/ryantate13/klogs/exec"
 	"github.com/ryantate13/klogs/fn"
+	"github.com/ryantate13/klogs/kube"
 	"github.com/ryantate13/klogs/logs"
+	"github.com/ryantate13/klogs/render"
 )
 
+// statsInterval is how often the stats and patterns tables are refreshed
+const statsInterval = time.Second
+
+// clearScreen moves the cursor to the top left of the terminal and clears it
+const clearScreen = "\033[H\033[2J"
+
 var (
 	//go:embed VERSION
 	v         string
 	version   = strings.TrimSpace(v)
+	isTTY     bool
 	ttyFormat string
 )
 
@@ -37,7 +48,7 @@ func fatal(s string) {
 }
 
 func init() {
-	isTTY := isatty.IsTerminal(os.Stdout.Fd())
+	isTTY = isatty.IsTerminal(os.Stdout.Fd())
 	color.NoColor = !isTTY
 	if isTTY {
 		out := term.Stdout()
@@ -118,33 +129,182 @@ func main() {
 			opts.Query = []string{}
 		}
 	}
-	if len(opts.Query) == 0 && len(opts.Label) == 0 {
+	local := opts.Replay != "" || len(opts.File) > 0 || opts.LogDir != ""
+	if len(opts.Query) == 0 && len(opts.Label) == 0 && !local {
 		fatal("Error: either pod name query or pod labels must be supplied\n\n" + opts.Usage())
 	}
+	// options are validated before any streams are started
+	renderer, err := render.New(opts, ttyFormat)
+	if err != nil {
+		fatal("Error: " + err.Error() + "\n\n" + opts.Usage())
+	}
+	arch, err := archive.New(opts)
+	if err != nil {
+		fatal("Error: " + err.Error() + "\n\n" + opts.Usage())
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
-		shutdown := make(chan os.Signal)
+		shutdown := make(chan os.Signal, 1)
 		signal.Notify(shutdown, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
 		<-shutdown
 		cancel()
+		// a second signal exits immediately, without waiting for streams to end or printing stats
+		<-shutdown
+		os.Exit(1)
 	}()
 
-	logChan, errChan, err := logs.Read(ctx, opts, exec.DefaultExecutor, ttyFormat)
+	// cluster returns the backend for the cluster of the context option
+	cluster := func(opts *args.Args) (logs.Backend, error) {
+		switch opts.Backend {
+		case "kubectl":
+			return logs.Kubectl(opts, exec.DefaultExecutor), nil
+		case "api":
+			client, err := kube.New(opts)
+			if err != nil {
+				return nil, fmt.Errorf("unable to load kubeconfig: %w", err)
+			}
+			return client, nil
+		}
+		fatal("Error: unknown backend \"" + opts.Backend + "\"\n\n" + opts.Usage())
+		return nil, nil
+	}
+	var backend logs.Backend
+	switch {
+	case opts.Replay != "":
+		f, err := os.Open(opts.Replay)
+		if err != nil {
+			fatal("Error: unable to open session: " + err.Error())
+		}
+		backend, err = logs.ReplaySession(opts, f)
+		f.Close()
+		if err != nil {
+			fatal(err.Error())
+		}
+	case len(opts.File) > 0:
+		backend = logs.Files(opts, os.Stdin)
+	case opts.LogDir != "":
+		backend = logs.LogDir(opts)
+	case opts.Backend == "docker":
+		backend = logs.Docker(opts, exec.DefaultExecutor)
+	case strings.ContainsAny(opts.Context, ",*?["):
+		// a list or pattern of contexts reads from each of their clusters in parallel
+		contexts, err := kube.Contexts(opts)
+		if err != nil {
+			fatal("Error: unable to load kubeconfig: " + err.Error())
+		}
+		backends := map[string]logs.Backend{}
+		for _, c := range contexts {
+			o := *opts
+			o.Context = c
+			if backends[c], err = cluster(&o); err != nil {
+				// the clusters of other contexts are read regardless
+				backends[c] = logs.Failed(err)
+			}
+		}
+		backend = logs.Clusters(backends)
+	default:
+		if backend, err = cluster(opts); err != nil {
+			fatal("Error: " + err.Error())
+		}
+	}
+	flush := func() error { return nil }
+	if opts.Record != "" {
+		f, err := os.Create(opts.Record)
+		if err != nil {
+			fatal("Error: unable to create session: " + err.Error())
+		}
+		defer f.Close()
+		backend, flush = logs.RecordSession(backend, f)
+	}
+
+	top, err := strconv.Atoi(opts.Top)
+	if err != nil {
+		fatal("Error: invalid number of patterns \"" + opts.Top + "\"\n\n" + opts.Usage())
+	}
+	var (
+		stats     *logs.Stats
+		patterns  *logs.Patterns
+		observers []logs.Observer
+	)
+	if opts.Stats || opts.Summary {
+		stats = logs.NewStats()
+		observers = append(observers, stats)
+	}
+	if opts.Patterns || opts.HighlightNew {
+		patterns = logs.NewPatterns()
+		observers = append(observers, patterns)
+	}
+	logChan, errChan, err := logs.Read(ctx, opts, backend, observers...)
 	if err != nil {
 		fatal(err.Error())
 	}
+	// tables are shown instead of log lines in stats and patterns mode
+	live := opts.Stats || opts.Patterns
+	tables := func(final bool) string {
+		var out []string
+		if stats != nil && final {
+			out = append(out, renderer.Stats(stats.Summary()))
+		} else if opts.Stats {
+			out = append(out, renderer.Stats(stats.Snapshot()))
+		}
+		if opts.Patterns {
+			out = append(out, renderer.Patterns(patterns.Top(top)))
+		}
+		return strings.Join(out, "\n\n")
+	}
+	var refresh <-chan time.Time
+	if live && isTTY {
+		t := time.NewTicker(statsInterval)
+		defer t.Stop()
+		refresh = t.C
+	}
 	for {
 		select {
 		case err = <-errChan:
 			if err != nil {
+				if arch != nil {
+					_ = arch.Close()
+				}
+				_ = flush()
 				fatal(err.Error())
 			}
-		case log, ok := <-logChan:
+		case <-refresh:
+			fmt.Println(clearScreen + tables(false))
+		case entry, ok := <-logChan:
 			if !ok {
+				if err = flush(); err != nil {
+					fatal("Error: unable to write session: " + err.Error())
+				}
+				if arch != nil {
+					if err = arch.Close(); err != nil {
+						fatal("Error: unable to write archive: " + err.Error())
+					}
+				}
+				summary := tables(true)
+				if live && isTTY {
+					fmt.Print(clearScreen)
+				} else if !live && summary != "" {
+					fmt.Println()
+				}
+				if summary != "" {
+					fmt.Println(summary)
+				}
 				return
 			}
-			fmt.Println(log)
+			if arch != nil {
+				if err = arch.Write(entry); err != nil {
+					fatal("Error: unable to write archive: " + err.Error())
+				}
+			}
+			if !live && !opts.ArchiveOnly {
+				out := os.Stdout
+				if entry.Notice && opts.Output != "ndjson" {
+					// notices are kept out of the log lines when output is redirected or piped
+					out = os.Stderr
+				}
+				fmt.Fprintln(out, renderer.Render(entry))
+			}
 		}
 	}
 }
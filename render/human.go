@@ -0,0 +1,119 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+
+	"github.com/ryantate13/klogs/logs"
+)
+
+// MaxValueLength is the number of characters after which field values are elided in human output
+var MaxValueLength = 80
+
+// human formats a JSON entry as "HH:MM:SS LEVEL message key=value...", using the text around embedded JSON as the
+// message if it has none, followed by the caller and error of the entry, the remaining fields with nested objects
+// flattened to dotted keys and sorted by key, and the continuation lines of multi-line entries and the stack trace on
+// the lines that follow
+func (r *Renderer) human(e *logs.Entry) string {
+	rec := e.Record
+	var parts []string
+	t := rec.Time
+	if t.IsZero() {
+		t = e.Time
+	}
+	if !t.IsZero() {
+		parts = append(parts, r.format(chroma.Token{Type: chroma.Comment, Value: t.Local().Format("15:04:05")}))
+	}
+	if e.Level != logs.LevelUnknown {
+		level := fmt.Sprintf("%-5s", strings.ToUpper(e.Level.String()))
+		if c, ok := levelColors[e.Level]; ok && r.tty != "" {
+			level = c("%s", level)
+		}
+		parts = append(parts, level)
+	}
+	first, rest, _ := strings.Cut(e.Line, "\n")
+	msg := rec.Message
+	if msg == "" && e.Embedded() {
+		msg = strings.TrimSpace(first[:e.JSONSpan[0]] + " " + first[e.JSONSpan[1]:])
+	}
+	if msg != "" {
+		parts = append(parts, r.format(chroma.Token{Type: chroma.Text, Value: msg}))
+	}
+	field := func(k string, v interface{}) string {
+		return r.format(chroma.Token{Type: chroma.NameTag, Value: k}) +
+			r.format(chroma.Token{Type: chroma.Operator, Value: "="}) +
+			r.value(v)
+	}
+	if rec.Caller != "" {
+		parts = append(parts, field("caller", rec.Caller))
+	}
+	if rec.Error != "" {
+		parts = append(parts, field("error", rec.Error))
+	}
+	fields := map[string]interface{}{}
+	flatten(fields, "", rec.Fields)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, field(k, fields[k]))
+	}
+	out := strings.Join(parts, " ")
+	if rest != "" {
+		out += "\n" + r.format(chroma.Token{Type: chroma.Text, Value: rest})
+	}
+	if rec.Stack != "" {
+		out += "\n" + r.format(chroma.Token{Type: chroma.Comment, Value: strings.TrimRight(rec.Stack, "\n")})
+	}
+	return out
+}
+
+// value formats a field value, quoting strings only if they contain spaces or special characters and eliding long
+// values
+func (r *Renderer) value(v interface{}) string {
+	t := chroma.Token{Type: chroma.LiteralString}
+	switch v := v.(type) {
+	case string:
+		t.Value = elide(v)
+		if t.Value == "" || strings.ContainsAny(t.Value, " \t\r\n\"=\\") {
+			t.Value, _ = marshal(t.Value)
+		}
+	case float64:
+		t.Type, t.Value = chroma.LiteralNumber, strconv.FormatFloat(v, 'f', -1, 64)
+	case bool, nil:
+		t.Type, t.Value = chroma.KeywordConstant, fmt.Sprint(v)
+		if v == nil {
+			t.Value = "null"
+		}
+	default:
+		s, _ := marshal(v)
+		t.Value = elide(s)
+	}
+	return r.format(t)
+}
+
+// elide shortens values longer than MaxValueLength characters
+func elide(s string) string {
+	runes := []rune(s)
+	if MaxValueLength <= 0 || len(runes) <= MaxValueLength {
+		return s
+	}
+	return string(runes[:MaxValueLength-1]) + "…"
+}
+
+// flatten copies the fields of nested objects into dst with dotted keys
+func flatten(dst map[string]interface{}, prefix string, fields map[string]interface{}) {
+	for k, v := range fields {
+		if obj, ok := v.(map[string]interface{}); ok && len(obj) > 0 {
+			flatten(dst, prefix+k+".", obj)
+			continue
+		}
+		dst[prefix+k] = v
+	}
+}
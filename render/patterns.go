@@ -0,0 +1,29 @@
+package render
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ryantate13/klogs/logs"
+)
+
+// MaxPatternPods is the number of pods listed for each pattern in the patterns table
+var MaxPatternPods = 3
+
+// Patterns formats patterns as a table of their counts, the pods that logged them and their templates
+func (r *Renderer) Patterns(patterns []logs.Pattern) string {
+	rows := [][]cell{{{text: "COUNT", right: true}, {text: "PODS"}, {text: "PATTERN"}}}
+	for _, p := range patterns {
+		pods := strings.Join(p.Pods, ", ")
+		if len(p.Pods) > MaxPatternPods {
+			pods = fmt.Sprintf("%s +%d more", strings.Join(p.Pods[:MaxPatternPods], ", "), len(p.Pods)-MaxPatternPods)
+		}
+		rows = append(rows, []cell{
+			{text: strconv.Itoa(p.Count), right: true},
+			{text: pods},
+			{text: p.Template},
+		})
+	}
+	return table(rows)
+}
@@ -0,0 +1,374 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/fatih/color"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/logs"
+)
+
+type colorFunc func(format string, a ...interface{}) string
+
+var (
+	colors = []colorFunc{
+		color.CyanString,
+		color.YellowString,
+		color.GreenString,
+		color.MagentaString,
+		color.BlueString,
+		color.HiCyanString,
+		color.HiYellowString,
+		color.HiGreenString,
+		color.HiMagentaString,
+		color.HiBlueString,
+	}
+	noColor         colorFunc = fmt.Sprintf
+	noticeColor     colorFunc = color.HiBlackString
+	matchColor                = color.New(color.Bold, color.ReverseVideo)
+	newPatternColor           = color.New(color.Bold, color.FgHiMagenta)
+	levelColors               = map[logs.Level]colorFunc{
+		logs.LevelTrace: color.HiBlackString,
+		logs.LevelDebug: color.BlueString,
+		logs.LevelInfo:  color.GreenString,
+		logs.LevelWarn:  color.YellowString,
+		logs.LevelError: color.RedString,
+		logs.LevelFatal: color.HiRedString,
+	}
+)
+
+// Renderer formats log entries as lines of text, adding prefixes, timestamps and colors according to the options
+type Renderer struct {
+	opts *args.Args
+	// tty is the chroma formatter name for the terminal, or empty if output is not to a terminal that supports color
+	tty    string
+	colors map[string]colorFunc
+	// fields are the paths of the JSON fields to project, if any
+	fields   []string
+	template *template.Template
+	// escapes caches the escape sequences of each type of token
+	escapes map[chroma.TokenType]escape
+}
+
+// New returns a Renderer for the given options and terminal format
+func New(opts *args.Args, tty string) (*Renderer, error) {
+	switch opts.Output {
+	case "", "text", "ndjson", "human":
+	default:
+		return nil, fmt.Errorf("unknown output format %q", opts.Output)
+	}
+	r := &Renderer{opts: opts, tty: tty, colors: map[string]colorFunc{}, escapes: map[chroma.TokenType]escape{}}
+	for _, f := range strings.Split(opts.Fields, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			r.fields = append(r.fields, f)
+		}
+	}
+	if opts.Template != "" {
+		t, err := parseTemplate(opts.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		r.template = t
+	}
+	return r, nil
+}
+
+// colorize returns the color for a pod, assigning colors in the order pods are first seen
+func (r *Renderer) colorize(e *logs.Entry) colorFunc {
+	if r.tty == "" {
+		return noColor
+	}
+	key := e.Context + "/" + e.Namespace + "/" + e.Pod
+	c, ok := r.colors[key]
+	if !ok {
+		c = colors[len(r.colors)%len(colors)]
+		r.colors[key] = c
+	}
+	return c
+}
+
+// Render formats a log entry for output
+func (r *Renderer) Render(e *logs.Entry) string {
+	e = r.project(e)
+	if r.opts.Output == "ndjson" {
+		return ndjson(e)
+	}
+	if e.Notice {
+		if r.tty == "" {
+			return "[klogs] " + e.Line
+		}
+		return noticeColor("[klogs] " + e.Line)
+	}
+	if r.template != nil {
+		if out, err := r.execute(e); err == nil {
+			return out
+		}
+	}
+	out := ""
+	if r.opts.Prefix {
+		prefix := "pod/" + e.Pod + "/" + e.Container
+		if e.Context != "" {
+			prefix = e.Context + "/" + prefix
+		}
+		out += r.colorize(e)("["+prefix+"]") + " "
+	}
+	if r.opts.Timestamps && !e.Time.IsZero() {
+		out += e.Time.UTC().Format(time.RFC3339Nano) + " "
+	}
+	if r.opts.HighlightNew && e.NewPattern {
+		if r.tty == "" {
+			out += "[new] "
+		} else {
+			out += newPatternColor.Sprint("[new]") + " "
+		}
+	}
+	switch {
+	case r.opts.Output == "human" && e.Record != nil:
+		out += r.human(e)
+	case r.tty == "":
+		out += e.Line
+	default:
+		out += r.colorLine(e)
+	}
+	if e.Repeated > 1 {
+		if r.tty == "" {
+			out += fmt.Sprintf(" (repeated %d times)", e.Repeated)
+		} else {
+			out += noticeColor(" (repeated %d times)", e.Repeated)
+		}
+	}
+	return out
+}
+
+// escape is the escape sequences the theme surrounds a type of token with
+type escape struct {
+	start, end string
+}
+
+// format colors a token with the theme. The escape sequences of each type of token are looked up once by formatting a
+// placeholder, since formatters rebuild their escape sequences for the whole theme on every call
+func (r *Renderer) format(t chroma.Token) string {
+	if r.tty == "" {
+		return t.Value
+	}
+	e, ok := r.escapes[t.Type]
+	if !ok {
+		f := formatters.Get(r.tty)
+		if f == nil {
+			f = formatters.Fallback
+		}
+		b := bytes.NewBuffer(nil)
+		placeholder := chroma.Literator(chroma.Token{Type: t.Type, Value: "\x00"})
+		if err := f.Format(b, styles.Get(r.opts.Theme), placeholder); err == nil {
+			e.start, e.end, _ = strings.Cut(b.String(), "\x00")
+		}
+		r.escapes[t.Type] = e
+	}
+	return e.start + t.Value + e.end
+}
+
+// logfmtTokens splits a logfmt line into tokens for syntax highlighting
+func logfmtTokens(line string) []chroma.Token {
+	pairs, _ := logs.ParseLogfmt(line)
+	var (
+		tokens []chroma.Token
+		offset int
+	)
+	for _, p := range pairs {
+		if p.Start > offset {
+			tokens = append(tokens, chroma.Token{Type: chroma.Text, Value: line[offset:p.Start]})
+		}
+		keyEnd := p.Start + len(p.Key)
+		tokens = append(tokens,
+			chroma.Token{Type: chroma.NameTag, Value: p.Key},
+			chroma.Token{Type: chroma.Operator, Value: "="},
+		)
+		if value := line[keyEnd+1 : p.End]; value != "" {
+			t := chroma.Token{Type: chroma.LiteralString, Value: value}
+			if _, err := strconv.ParseFloat(value, 64); err == nil {
+				t.Type = chroma.LiteralNumber
+			}
+			tokens = append(tokens, t)
+		}
+		offset = p.End
+	}
+	if offset < len(line) {
+		tokens = append(tokens, chroma.Token{Type: chroma.Text, Value: line[offset:]})
+	}
+	return tokens
+}
+
+// span is a range of a line that is styled on top of any syntax highlighting
+type span struct {
+	start, end int
+	// plain spans are styled from the text of the line rather than its syntax highlighted text
+	plain bool
+	style func(string) string
+}
+
+// colorLine adds JSON syntax highlighting, level colors and match highlighting to a line. Syntax highlighting tokens
+// are split at the boundaries of each styled span so that they can be combined
+func (r *Renderer) colorLine(e *logs.Entry) string {
+	var spans []span
+	if c, ok := levelColors[e.Level]; ok && e.LevelSpan != nil {
+		spans = append(spans, span{start: e.LevelSpan[0], end: e.LevelSpan[1], plain: true, style: func(s string) string {
+			return c("%s", s)
+		}})
+	}
+	if r.opts.Highlight {
+		for _, m := range e.Matches {
+			spans = append(spans, span{start: m[0], end: m[1], style: func(s string) string {
+				return matchColor.Sprint(s)
+			}})
+		}
+	}
+	highlight := r.opts.JSON && e.Fields != nil
+	if len(spans) == 0 && !highlight {
+		return e.Line
+	}
+	tokens := []chroma.Token{{Type: chroma.Text, Value: e.Line}}
+	format := func(t chroma.Token) string {
+		return t.Value
+	}
+	if highlight && e.Format == logs.FormatLogfmt {
+		tokens, format = logfmtTokens(e.Line), r.format
+	} else if highlight {
+		start, end := 0, len(e.Line)
+		if e.Embedded() {
+			start, end = e.JSONSpan[0], e.JSONSpan[1]
+		}
+		if it, err := chroma.Coalesce(lexers.Get("json")).Tokenise(nil, e.Line[start:end]); err == nil {
+			tokens = append([]chroma.Token{{Type: chroma.Text, Value: e.Line[:start]}}, it.Tokens()...)
+			tokens, format = append(tokens, chroma.Token{Type: chroma.Text, Value: e.Line[end:]}), r.format
+		}
+	}
+	var (
+		out    strings.Builder
+		offset int
+	)
+	for _, t := range tokens {
+		value := t.Value
+		if rest := len(e.Line) - offset; len(value) > rest {
+			value = value[:rest]
+		}
+		for value != "" {
+			n := len(value)
+			for _, s := range spans {
+				for _, boundary := range []int{s.start, s.end} {
+					if boundary > offset && boundary-offset < n {
+						n = boundary - offset
+					}
+				}
+			}
+			text := value[:n]
+			segment := ""
+			for _, s := range spans {
+				if s.plain && s.start <= offset && offset < s.end {
+					segment = s.style(text)
+				}
+			}
+			if segment == "" {
+				segment = format(chroma.Token{Type: t.Type, Value: text})
+			}
+			for _, s := range spans {
+				if !s.plain && s.start <= offset && offset < s.end {
+					segment = s.style(segment)
+				}
+			}
+			out.WriteString(segment)
+			value = value[n:]
+			offset += n
+		}
+	}
+	return out.String()
+}
+
+type jsonEntry struct {
+	Timestamp string      `json:"timestamp,omitempty"`
+	Context   string      `json:"context,omitempty"`
+	Namespace string      `json:"namespace,omitempty"`
+	Pod       string      `json:"pod,omitempty"`
+	Container string      `json:"container,omitempty"`
+	Stream    string      `json:"stream,omitempty"`
+	Level     string      `json:"level,omitempty"`
+	Record    *jsonRecord `json:"record,omitempty"`
+	// Fields holds the fields of logfmt entries and of JSON embedded in text, whose messages are strings
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// Pattern is the template of the message, if patterns are being clustered
+	Pattern    string `json:"pattern,omitempty"`
+	NewPattern bool   `json:"new_pattern,omitempty"`
+	// Repeated is the number of times the entry was repeated, if repeated lines are collapsed and it was repeated
+	Repeated int         `json:"repeated,omitempty"`
+	Notice   bool        `json:"notice,omitempty"`
+	Message  interface{} `json:"message"`
+}
+
+// jsonRecord holds the common fields of a structured log entry normalized across logging library schemas
+type jsonRecord struct {
+	Schema  string `json:"schema"`
+	Time    string `json:"time,omitempty"`
+	Message string `json:"msg,omitempty"`
+	Caller  string `json:"caller,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Stack   string `json:"stack,omitempty"`
+}
+
+// ndjson formats a log entry as a single line JSON object. Messages that are JSON objects are nested as-is, everything
+// else is a string
+func ndjson(e *logs.Entry) string {
+	j := &jsonEntry{
+		Context:    e.Context,
+		Namespace:  e.Namespace,
+		Pod:        e.Pod,
+		Container:  e.Container,
+		Stream:     e.Stream,
+		Level:      e.Level.String(),
+		Pattern:    e.Pattern,
+		NewPattern: e.NewPattern,
+		Notice:     e.Notice,
+		Message:    e.Line,
+	}
+	if !e.Time.IsZero() {
+		j.Timestamp = e.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if e.Repeated > 1 {
+		j.Repeated = e.Repeated
+	}
+	if e.Format == logs.FormatJSON && !e.Embedded() {
+		j.Message = json.RawMessage(e.Line)
+	} else {
+		j.Fields = e.Fields
+	}
+	if rec := e.Record; rec != nil {
+		j.Record = &jsonRecord{
+			Schema:  rec.Schema,
+			Message: rec.Message,
+			Caller:  rec.Caller,
+			Error:   rec.Error,
+			Stack:   rec.Stack,
+		}
+		if !rec.Time.IsZero() {
+			j.Record.Time = rec.Time.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	b := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(j); err != nil {
+		j.Message = e.Line
+		b.Reset()
+		_ = enc.Encode(j)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
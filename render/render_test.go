@@ -0,0 +1,461 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/quick"
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ryantate13/klogs/args"
+	"github.com/ryantate13/klogs/logs"
+)
+
+func highlight(t *testing.T, s string) string {
+	b := bytes.NewBuffer(nil)
+	require.NoError(t, quick.Highlight(b, s, "json", "terminal256", "nord"))
+	return b.String()
+}
+
+func TestRender(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+	entry := &logs.Entry{
+		Pod:       "foo",
+		Namespace: "ns",
+		Container: "app",
+		Line:      `{"a":1}`,
+		Time:      time.Date(2022, 11, 9, 12, 0, 0, 1, time.UTC),
+		Fields:    map[string]interface{}{"a": float64(1)},
+		Format:    logs.FormatJSON,
+	}
+	nested := map[string]interface{}{
+		"http":  map[string]interface{}{"status": float64(500)},
+		"level": "error",
+		"msg":   "<failed>",
+	}
+	tests := []struct {
+		it    string
+		opts  *args.Args
+		tty   string
+		entry *logs.Entry
+		want  string
+	}{
+		{
+			it:    "renders the log entry",
+			opts:  &args.Args{},
+			entry: entry,
+			want:  `{"a":1}`,
+		},
+		{
+			it:    "adds prefixes and timestamps",
+			opts:  &args.Args{Prefix: true, Timestamps: true},
+			entry: entry,
+			want:  `[pod/foo/app] 2022-11-09T12:00:00.000000001Z {"a":1}`,
+		},
+		{
+			it:    "leaves out the timestamps of entries without one",
+			opts:  &args.Args{Prefix: true, Timestamps: true},
+			entry: &logs.Entry{Pod: "stdin", Line: "plain line"},
+			want:  "[pod/stdin/] plain line",
+		},
+		{
+			it:    "colors prefixes by pod",
+			opts:  &args.Args{Prefix: true},
+			tty:   "terminal",
+			entry: entry,
+			want:  color.CyanString("[pod/foo/app]") + ` {"a":1}`,
+		},
+		{
+			it:    "highlights JSON log entries",
+			opts:  &args.Args{JSON: true, Theme: "nord"},
+			tty:   "terminal256",
+			entry: entry,
+			want:  highlight(t, `{"a":1}`),
+		},
+		{
+			it:    "highlights JSON embedded in text",
+			opts:  &args.Args{JSON: true, Theme: "nord"},
+			tty:   "terminal256",
+			entry: &logs.Entry{Line: `handler: {"a":1} done`, Fields: entry.Fields, Format: logs.FormatJSON, JSONSpan: []int{9, 16}},
+			want:  "handler: " + highlight(t, `{"a":1}`) + " done",
+		},
+		{
+			it:    "does not highlight JSON unless outputting to a terminal",
+			opts:  &args.Args{JSON: true, Theme: "nord"},
+			entry: entry,
+			want:  `{"a":1}`,
+		},
+		{
+			it:    "highlights the parts of lines matched by include patterns",
+			opts:  &args.Args{Highlight: true},
+			tty:   "terminal",
+			entry: &logs.Entry{Line: "GET /api 500", Matches: [][]int{{0, 3}, {9, 12}}},
+			want:  matchColor.Sprint("GET") + " /api " + matchColor.Sprint("500"),
+		},
+		{
+			it:    "highlights matches within highlighted JSON",
+			opts:  &args.Args{Highlight: true, JSON: true, Theme: "nord"},
+			tty:   "terminal256",
+			entry: &logs.Entry{Line: `{"a":1}`, Fields: entry.Fields, Matches: [][]int{{5, 6}}},
+			want:  strings.Replace(highlight(t, `{"a":1}`), highlight(t, "1"), matchColor.Sprint(highlight(t, "1")), 1),
+		},
+		{
+			it:    "colors levels",
+			opts:  &args.Args{},
+			tty:   "terminal",
+			entry: &logs.Entry{Line: "12:00 WARN slow", Level: logs.LevelWarn, LevelSpan: []int{6, 10}},
+			want:  "12:00 " + color.YellowString("WARN") + " slow",
+		},
+		{
+			it:    "does not highlight matches unless outputting to a terminal",
+			opts:  &args.Args{Highlight: true},
+			entry: &logs.Entry{Line: "GET /api 500", Matches: [][]int{{0, 3}}},
+			want:  "GET /api 500",
+		},
+		{
+			it:    "projects the selected fields of JSON log entries",
+			opts:  &args.Args{Fields: "msg, http.status,missing"},
+			entry: &logs.Entry{Line: `{"http":{"status":500},"level":"error","msg":"<failed>"}`, Fields: nested, Format: logs.FormatJSON},
+			want:  `{"msg":"<failed>","http.status":500}`,
+		},
+		{
+			it:    "projects the selected fields of logfmt log entries",
+			opts:  &args.Args{Fields: "msg,level"},
+			entry: &logs.Entry{Line: `level=warn msg="disk full" path=/data`, Fields: map[string]interface{}{"level": "warn", "msg": "disk full", "path": "/data"}, Format: logs.FormatLogfmt},
+			want:  `msg="disk full" level=warn`,
+		},
+		{
+			it:    "does not project other log entries",
+			opts:  &args.Args{Fields: "msg"},
+			entry: &logs.Entry{Line: "not json"},
+			want:  "not json",
+		},
+		{
+			it:    "projects fields in ndjson output",
+			opts:  &args.Args{Output: "ndjson", Fields: "level"},
+			entry: &logs.Entry{Line: `{"http":{"status":500},"level":"error","msg":"<failed>"}`, Fields: nested, Format: logs.FormatJSON},
+			want:  `{"message":{"level":"error"}}`,
+		},
+		{
+			it: "formats entries with a template",
+			opts: &args.Args{
+				Prefix:   true,
+				Template: `{{.Namespace}}/{{.Pod}}/{{.Container}} {{.Timestamp.Format "15:04:05"}} {{.Field "http.status"}} {{.Fields.msg}} {{json .Fields.http}} [{{.Field "missing"}}]`,
+			},
+			entry: &logs.Entry{
+				Pod:       "foo",
+				Namespace: "ns",
+				Container: "app",
+				Line:      `{"http":{"status":500},"level":"error","msg":"<failed>"}`,
+				Time:      time.Date(2022, 11, 9, 12, 0, 0, 1, time.UTC),
+				Fields:    nested,
+			},
+			want: `ns/foo/app 12:00:00 500 <failed> {"status":500} []`,
+		},
+		{
+			it:    "formats projected entries with a template",
+			opts:  &args.Args{Fields: "level", Template: `{{.Message}}`},
+			entry: &logs.Entry{Line: `{"http":{"status":500},"level":"error","msg":"<failed>"}`, Fields: nested, Format: logs.FormatJSON},
+			want:  `{"level":"error"}`,
+		},
+		{
+			it:    "renders notices",
+			opts:  &args.Args{Prefix: true, Timestamps: true},
+			entry: &logs.Entry{Line: "pod ns/foo added", Notice: true},
+			want:  "[klogs] pod ns/foo added",
+		},
+		{
+			it:    "renders JSON log entries as nested objects in ndjson output",
+			opts:  &args.Args{Output: "ndjson", Prefix: true},
+			tty:   "terminal",
+			entry: entry,
+			want:  `{"timestamp":"2022-11-09T12:00:00.000000001Z","namespace":"ns","pod":"foo","container":"app","message":{"a":1}}`,
+		},
+		{
+			it:    "adds the context of entries from several clusters to prefixes",
+			opts:  &args.Args{Prefix: true},
+			entry: &logs.Entry{Pod: "foo", Namespace: "ns", Container: "app", Context: "prod", Line: "one"},
+			want:  "[prod/pod/foo/app] one",
+		},
+		{
+			it:    "adds the context of entries from several clusters to ndjson output",
+			opts:  &args.Args{Output: "ndjson"},
+			entry: &logs.Entry{Pod: "foo", Namespace: "ns", Container: "app", Context: "prod", Line: "one"},
+			want:  `{"context":"prod","namespace":"ns","pod":"foo","container":"app","message":"one"}`,
+		},
+		{
+			it:   "renders other log entries as strings in ndjson output",
+			opts: &args.Args{Output: "ndjson"},
+			entry: &logs.Entry{
+				Pod:       "foo",
+				Namespace: "ns",
+				Container: "app",
+				Line:      "<b>not json</b>",
+				Time:      time.Date(2022, 11, 9, 12, 0, 0, 0, time.UTC),
+				Stream:    "stderr",
+			},
+			want: `{"timestamp":"2022-11-09T12:00:00Z","namespace":"ns","pod":"foo","container":"app","stream":"stderr","message":"<b>not json</b>"}`,
+		},
+		{
+			it:   "adds normalized records to ndjson output",
+			opts: &args.Args{Output: "ndjson"},
+			entry: &logs.Entry{
+				Line:  `{"@t":"2022-11-09T12:00:00Z","@mt":"failed","@x":"Boom\n at Main()"}`,
+				Level: logs.LevelInfo,
+				Record: logs.Normalize(map[string]interface{}{
+					"@t":  "2022-11-09T12:00:00Z",
+					"@mt": "failed",
+					"@x":  "Boom\n at Main()",
+				}, logs.FormatJSON),
+				Fields: map[string]interface{}{},
+				Format: logs.FormatJSON,
+			},
+			want: `{"level":"info","record":{"schema":"serilog","time":"2022-11-09T12:00:00Z","msg":"failed","error":"Boom","stack":"Boom\n at Main()"},"message":{"@t":"2022-11-09T12:00:00Z","@mt":"failed","@x":"Boom\n at Main()"}}`,
+		},
+		{
+			it:   "adds the fields of embedded JSON to ndjson output",
+			opts: &args.Args{Output: "ndjson"},
+			entry: &logs.Entry{
+				Line:     `handler: {"a":1}`,
+				Fields:   map[string]interface{}{"a": float64(1)},
+				Format:   logs.FormatJSON,
+				JSONSpan: []int{9, 16},
+			},
+			want: `{"fields":{"a":1},"message":"handler: {\"a\":1}"}`,
+		},
+		{
+			it:    "renders notices in ndjson output",
+			opts:  &args.Args{Output: "ndjson"},
+			entry: &logs.Entry{Line: "pod ns/foo added", Notice: true},
+			want:  `{"notice":true,"message":"pod ns/foo added"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.it, func(t *testing.T) {
+			r, err := New(tt.opts, tt.tty)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, r.Render(tt.entry))
+		})
+	}
+}
+
+func TestColorLine(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+	t.Run("colors levels within highlighted JSON", func(t *testing.T) {
+		r, err := New(&args.Args{JSON: true, Theme: "nord", Highlight: true}, "terminal256")
+		require.NoError(t, err)
+		line := `{"level":"error","msg":"failed"}`
+		got := r.Render(&logs.Entry{
+			Line:      line,
+			Fields:    map[string]interface{}{"level": "error", "msg": "failed"},
+			Level:     logs.LevelError,
+			LevelSpan: []int{10, 15},
+			Matches:   [][]int{{12, 13}},
+		})
+		require.Contains(t, got, color.RedString("er")+matchColor.Sprint(color.RedString("r"))+color.RedString("or"))
+		require.Equal(t, line, regexp.MustCompile("\x1b\\[[0-9;]*m").ReplaceAllString(got, ""))
+	})
+}
+
+func TestLogfmt(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+	t.Run("highlights logfmt log entries with the theme", func(t *testing.T) {
+		r, err := New(&args.Args{JSON: true, Theme: "nord"}, "terminal256")
+		require.NoError(t, err)
+		token := func(tt chroma.TokenType, v string) string {
+			return r.format(chroma.Token{Type: tt, Value: v})
+		}
+		got := r.Render(&logs.Entry{
+			Line:      `level=warn  msg="disk full" n=3`,
+			Fields:    map[string]interface{}{"level": "warn", "msg": "disk full", "n": "3"},
+			Format:    logs.FormatLogfmt,
+			Level:     logs.LevelWarn,
+			LevelSpan: []int{6, 10},
+		})
+		require.Equal(t, token(chroma.NameTag, "level")+token(chroma.Operator, "=")+color.YellowString("warn")+
+			token(chroma.Text, "  ")+token(chroma.NameTag, "msg")+token(chroma.Operator, "=")+
+			token(chroma.LiteralString, `"disk full"`)+token(chroma.Text, " ")+
+			token(chroma.NameTag, "n")+token(chroma.Operator, "=")+token(chroma.LiteralNumber, "3"), got)
+	})
+}
+
+func TestHuman(t *testing.T) {
+	defer func(noColor bool, local *time.Location, max int) {
+		color.NoColor, time.Local, MaxValueLength = noColor, local, max
+	}(color.NoColor, time.Local, MaxValueLength)
+	color.NoColor, time.Local, MaxValueLength = false, time.UTC, 10
+	line := `{"level":"warn","time":1668000000123,"msg":"slow request","http":{"method":"GET","status":200},` +
+		`"query":"a=1","path":"/api/v1/very/long/path","tags":["a"],"ok":true,"user":{}}`
+	entry := &logs.Entry{
+		Container: "app",
+		Pod:       "foo",
+		Line:      line,
+		Time:      time.Date(2022, 11, 9, 12, 0, 0, 0, time.UTC),
+		Fields:    map[string]interface{}{},
+		Level:     logs.LevelWarn,
+	}
+	require.NoError(t, json.Unmarshal([]byte(line), &entry.Fields))
+	entry.Record = logs.Normalize(entry.Fields, logs.FormatJSON)
+	t.Run("formats JSON entries for humans", func(t *testing.T) {
+		r, err := New(&args.Args{Output: "human", Prefix: true}, "")
+		require.NoError(t, err)
+		require.Equal(t,
+			`[pod/foo/app] 13:20:00 WARN  slow request http.method=GET http.status=200 ok=true path=/api/v1/v… query="a=1" tags=["a"] user={}`,
+			r.Render(entry))
+	})
+	t.Run("colors levels and uses the theme for everything else", func(t *testing.T) {
+		r, err := New(&args.Args{Output: "human", Theme: "nord"}, "terminal256")
+		require.NoError(t, err)
+		got := r.Render(entry)
+		require.Contains(t, got, color.YellowString("WARN "))
+		require.Contains(t, got, r.format(chroma.Token{Type: chroma.NameTag, Value: "http.method"}))
+	})
+	t.Run("shows the caller, error and stack of normalized entries", func(t *testing.T) {
+		r, err := New(&args.Args{Output: "human"}, "")
+		require.NoError(t, err)
+		fields := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(`{"level":"error","ts":1668000000.5,"caller":"main.go:12",`+
+			`"msg":"failed","error":"boom","stacktrace":"main.main\n\tmain.go:12","id":1}`), &fields))
+		require.Equal(t, "13:20:00 ERROR failed caller=main.go:12 error=boom id=1\nmain.main\n\tmain.go:12", r.Render(&logs.Entry{
+			Line:   "{}",
+			Fields: fields,
+			Level:  logs.LevelError,
+			Record: logs.Normalize(fields, logs.FormatJSON),
+		}))
+	})
+	t.Run("shows the continuation lines of multi-line entries", func(t *testing.T) {
+		r, err := New(&args.Args{Output: "human"}, "")
+		require.NoError(t, err)
+		fields := map[string]interface{}{"error": "boom"}
+		require.Equal(t, "request failed error=boom\n\tat Main.main(Main.java:5)", r.Render(&logs.Entry{
+			Line:     `request failed {"error":"boom"}` + "\n\tat Main.main(Main.java:5)",
+			Fields:   fields,
+			Format:   logs.FormatJSON,
+			JSONSpan: []int{15, 31},
+			Record:   logs.Normalize(fields, logs.FormatJSON),
+		}))
+	})
+	t.Run("renders other entries as text", func(t *testing.T) {
+		r, err := New(&args.Args{Output: "human"}, "")
+		require.NoError(t, err)
+		require.Equal(t, "plain text", r.Render(&logs.Entry{Line: "plain text"}))
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Run("returns an error for unknown output formats", func(t *testing.T) {
+		_, err := New(&args.Args{Output: "xml"}, "")
+		require.EqualError(t, err, `unknown output format "xml"`)
+	})
+	t.Run("returns an error for invalid templates", func(t *testing.T) {
+		_, err := New(&args.Args{Template: "{{.Pod"}, "")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid template")
+	})
+}
+
+func TestStats(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+	app := logs.Counter{Namespace: "default", Pod: "foo", Container: "app", Lines: 1500, Bytes: 2048, Rate: 2.5}
+	app.Levels[logs.LevelInfo], app.Levels[logs.LevelError] = 1490, 10
+	sidecar := logs.Counter{Namespace: "default", Pod: "foo", Container: "sidecar", Lines: 3, Bytes: 100}
+	bar := logs.Counter{Namespace: "ns", Pod: "bar", Container: "app", Lines: 2, Bytes: 20, Rate: 0.5}
+	bar.Levels[logs.LevelWarn] = 2
+	counters := []logs.Counter{app, sidecar, bar}
+	t.Run("formats counters as a table with pod totals", func(t *testing.T) {
+		r, err := New(&args.Args{}, "")
+		require.NoError(t, err)
+		require.Equal(t, strings.Join([]string{
+			"POD          CONTAINER  LINES    BYTES  LINES/S  TRACE  DEBUG  INFO  WARN  ERROR  FATAL",
+			"default/foo  *           1503  2.1 KiB      2.5      0      0  1490     0     10      0",
+			"default/foo  app         1500  2.0 KiB      2.5      0      0  1490     0     10      0",
+			"default/foo  sidecar        3    100 B      0.0      0      0     0     0      0      0",
+			"ns/bar       app            2     20 B      0.5      0      0     0     2      0      0",
+			"TOTAL                    1505  2.1 KiB      3.0      0      0  1490     2     10      0",
+		}, "\n"), r.Stats(counters))
+	})
+	t.Run("colors pods and counts of warnings and errors", func(t *testing.T) {
+		r, err := New(&args.Args{}, "terminal256")
+		require.NoError(t, err)
+		got := r.Stats(counters)
+		require.Contains(t, got, colors[0]("%s", "default/foo"))
+		require.Contains(t, got, colors[1]("%s", "ns/bar"))
+		require.Contains(t, got, color.RedString("10"))
+		require.Contains(t, got, color.YellowString("2"))
+		require.NotContains(t, got, color.RedString("0"))
+	})
+}
+
+func TestPatterns(t *testing.T) {
+	defer func(max int) { MaxPatternPods = max }(MaxPatternPods)
+	MaxPatternPods = 2
+	r, err := New(&args.Args{}, "")
+	require.NoError(t, err)
+	require.Equal(t, strings.Join([]string{
+		"COUNT  PODS                          PATTERN",
+		"  120  default/a, default/b +1 more  user <*> logged in from <ip>",
+		"    3  default/a                     cache miss for key <num>",
+	}, "\n"), r.Patterns([]logs.Pattern{
+		{Template: "user <*> logged in from <ip>", Count: 120, Pods: []string{"default/a", "default/b", "default/c"}},
+		{Template: "cache miss for key <num>", Count: 3, Pods: []string{"default/a"}},
+	}))
+}
+
+func TestHighlightNew(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+	entry := &logs.Entry{Pod: "foo", Container: "app", Line: "cache miss for key 42", NewPattern: true}
+	for _, tt := range []struct {
+		it   string
+		opts *args.Args
+		tty  string
+		want string
+	}{
+		{
+			it:   "marks entries with new patterns",
+			opts: &args.Args{HighlightNew: true, Prefix: true},
+			want: "[pod/foo/app] [new] cache miss for key 42",
+		},
+		{
+			it:   "colors the mark on a terminal",
+			opts: &args.Args{HighlightNew: true},
+			tty:  "terminal256",
+			want: newPatternColor.Sprint("[new]") + " cache miss for key 42",
+		},
+		{
+			it:   "does not mark entries unless enabled",
+			opts: &args.Args{},
+			want: "cache miss for key 42",
+		},
+	} {
+		t.Run(tt.it, func(t *testing.T) {
+			r, err := New(tt.opts, tt.tty)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, r.Render(entry))
+		})
+	}
+}
+
+func TestRepeated(t *testing.T) {
+	entry := &logs.Entry{Pod: "foo", Container: "app", Line: "retrying", Repeated: 3}
+	r, err := New(&args.Args{Prefix: true}, "")
+	require.NoError(t, err)
+	require.Equal(t, "[pod/foo/app] retrying (repeated 3 times)", r.Render(entry))
+	require.Equal(t, "[pod/foo/app] retrying", r.Render(&logs.Entry{Pod: "foo", Container: "app", Line: "retrying", Repeated: 1}))
+	r, err = New(&args.Args{Output: "ndjson"}, "")
+	require.NoError(t, err)
+	require.Equal(t, `{"pod":"foo","container":"app","repeated":3,"message":"retrying"}`, r.Render(entry))
+	r, err = New(&args.Args{Template: "{{.Message}} x{{.Repeated}}"}, "")
+	require.NoError(t, err)
+	require.Equal(t, "retrying x3", r.Render(entry))
+}
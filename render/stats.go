@@ -0,0 +1,129 @@
+package render
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ryantate13/klogs/logs"
+)
+
+// statsLevels are the levels counted in the stats table, in column order
+var statsLevels = []logs.Level{
+	logs.LevelTrace, logs.LevelDebug, logs.LevelInfo, logs.LevelWarn, logs.LevelError, logs.LevelFatal,
+}
+
+// size formats a number of bytes with a binary unit
+func size(n int) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	v, unit := float64(n), 0
+	for v >= 1024 && unit < len(units)-1 {
+		v /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return strconv.Itoa(n) + " B"
+	}
+	return fmt.Sprintf("%.1f %s", v, units[unit])
+}
+
+// cell is a cell of a table, with a color applied after the columns are aligned
+type cell struct {
+	text  string
+	color colorFunc
+	// right aligns the cell to the right of its column, as for numbers
+	right bool
+}
+
+// Stats formats the counters of each container as a table with a row per container, preceded by a row of totals for
+// pods with several containers, and followed by a row of totals for all pods. Warning, error and fatal counts are
+// colored by level
+func (r *Renderer) Stats(counters []logs.Counter) string {
+	header := []string{"POD", "CONTAINER", "LINES", "BYTES", "LINES/S"}
+	for _, l := range statsLevels {
+		header = append(header, strings.ToUpper(l.String()))
+	}
+	rows := [][]cell{make([]cell, len(header))}
+	for i, h := range header {
+		rows[0][i] = cell{text: h, right: i >= 2}
+	}
+	row := func(pod, container string, c logs.Counter, podColor colorFunc) []cell {
+		cells := []cell{
+			{text: pod, color: podColor},
+			{text: container},
+			{text: strconv.Itoa(c.Lines), right: true},
+			{text: size(c.Bytes), right: true},
+			{text: strconv.FormatFloat(c.Rate, 'f', 1, 64), right: true},
+		}
+		for _, l := range statsLevels {
+			count := cell{text: strconv.Itoa(c.Levels[l]), right: true}
+			if c.Levels[l] > 0 && l >= logs.LevelWarn && r.tty != "" {
+				count.color = levelColors[l]
+			}
+			cells = append(cells, count)
+		}
+		return cells
+	}
+	add := func(total *logs.Counter, c logs.Counter) {
+		total.Lines += c.Lines
+		total.Bytes += c.Bytes
+		total.Rate += c.Rate
+		for l, n := range c.Levels {
+			total.Levels[l] += n
+		}
+	}
+	var total logs.Counter
+	for i := 0; i < len(counters); {
+		pod := counters[i]
+		j := i
+		var podTotal logs.Counter
+		for ; j < len(counters) && counters[j].Context == pod.Context && counters[j].Namespace == pod.Namespace &&
+			counters[j].Pod == pod.Pod; j++ {
+			add(&podTotal, counters[j])
+		}
+		name := pod.Namespace + "/" + pod.Pod
+		if pod.Context != "" {
+			name = pod.Context + "/" + name
+		}
+		c := r.colorize(&logs.Entry{Context: pod.Context, Namespace: pod.Namespace, Pod: pod.Pod})
+		if j-i > 1 {
+			rows = append(rows, row(name, "*", podTotal, c))
+		}
+		for ; i < j; i++ {
+			rows = append(rows, row(name, counters[i].Container, counters[i], c))
+		}
+		add(&total, podTotal)
+	}
+	rows = append(rows, row("TOTAL", "", total, nil))
+	return table(rows)
+}
+
+// table aligns the cells of each row into columns
+func table(rows [][]cell) string {
+	widths := make([]int, len(rows[0]))
+	for _, cells := range rows {
+		for i, cell := range cells {
+			if n := len([]rune(cell.text)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	lines := make([]string, len(rows))
+	for i, cells := range rows {
+		parts := make([]string, len(cells))
+		for j, cell := range cells {
+			padding := strings.Repeat(" ", widths[j]-len([]rune(cell.text)))
+			text := cell.text
+			if cell.color != nil {
+				text = cell.color("%s", text)
+			}
+			if cell.right {
+				parts[j] = padding + text
+			} else {
+				parts[j] = text + padding
+			}
+		}
+		lines[i] = strings.TrimRight(strings.Join(parts, "  "), " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,125 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ryantate13/klogs/expr"
+	"github.com/ryantate13/klogs/logs"
+)
+
+// templateData is the value that output templates are executed with
+type templateData struct {
+	Pod       string
+	Namespace string
+	Container string
+	Stream    string
+	// Context is the kubeconfig context of the cluster of the pod, when reading from several clusters
+	Context   string
+	Timestamp time.Time
+	// Level is the detected level of the entry, or an empty string if there is none
+	Level string
+	// Message is the log line, after any field projection
+	Message string
+	// Fields is the log line parsed as JSON or logfmt, or nil if it is neither
+	Fields map[string]interface{}
+	// Repeated is the number of consecutive times the line was written, if repeated lines are collapsed
+	Repeated int
+}
+
+// Field returns the value at a dotted path in the parsed fields of the log line, or an empty string if there is none
+func (d *templateData) Field(path string) interface{} {
+	if v, ok := expr.Lookup(d.Fields, path); ok && v != nil {
+		return v
+	}
+	return ""
+}
+
+var templateFuncs = template.FuncMap{
+	"json": marshal,
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("output").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// execute renders an entry with the output template
+func (r *Renderer) execute(e *logs.Entry) (string, error) {
+	b := bytes.NewBuffer(nil)
+	err := r.template.Execute(b, &templateData{
+		Pod:       e.Pod,
+		Namespace: e.Namespace,
+		Container: e.Container,
+		Stream:    e.Stream,
+		Context:   e.Context,
+		Timestamp: e.Time,
+		Level:     e.Level.String(),
+		Message:   e.Line,
+		Fields:    e.Fields,
+		Repeated:  e.Repeated,
+	})
+	return strings.TrimSuffix(b.String(), "\n"), err
+}
+
+// marshal encodes a value as JSON without escaping HTML characters, which are common in log messages
+func marshal(v interface{}) (string, error) {
+	b := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// project returns a copy of a JSON or logfmt entry with only the selected fields, in the order they were selected.
+// Fields are dotted paths, and those missing from the entry are left out
+func (r *Renderer) project(e *logs.Entry) *logs.Entry {
+	if len(r.fields) == 0 || e.Fields == nil {
+		return e
+	}
+	projected := *e
+	projected.Fields = map[string]interface{}{}
+	projected.Matches = nil
+	projected.LevelSpan = nil
+	parts := make([]string, 0, len(r.fields))
+	for _, f := range r.fields {
+		v, ok := expr.Lookup(e.Fields, f)
+		if !ok {
+			continue
+		}
+		projected.Fields[f] = v
+		if e.Format == logs.FormatLogfmt {
+			s, _ := v.(string)
+			parts = append(parts, f+"="+logs.QuoteLogfmt(s))
+			continue
+		}
+		k, _ := marshal(f)
+		val, err := marshal(v)
+		if err != nil {
+			continue
+		}
+		parts = append(parts, k+":"+val)
+	}
+	fields := projected.Fields
+	if e.Format == logs.FormatLogfmt {
+		projected.Line = strings.Join(parts, " ")
+		fields = nil
+	} else {
+		projected.Line = "{" + strings.Join(parts, ",") + "}"
+		projected.JSONSpan = []int{0, len(projected.Line)}
+	}
+	if e.Record != nil {
+		projected.Record = logs.Normalize(projected.Fields, e.Format)
+	}
+	if l, span := logs.DetectLevel(projected.Line, fields); l == e.Level {
+		projected.LevelSpan = span
+	}
+	if rest := e.Continuation(); rest != "" {
+		projected.Line += "\n" + rest
+	}
+	return &projected
+}